@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accountPage 邮件链接打开的页面数据
+type accountPage struct {
+	// Action 页面提交的接口，resetpassword或verifyemail
+	Action string
+	Token  string
+}
+
+// ResetPasswordPage 重置密码邮件中的链接打开的页面，输入新密码后提交到/user/resetpassword
+func (c *UserController) ResetPasswordPage(ctx *gin.Context) {
+	renderAccountPage(ctx, accountPage{Action: "resetpassword", Token: ctx.Query("token")})
+}
+
+// VerifyEmailPage 验证邮箱邮件中的链接打开的页面，打开后自动提交到/user/verifyemail
+func (c *UserController) VerifyEmailPage(ctx *gin.Context) {
+	renderAccountPage(ctx, accountPage{Action: "verifyemail", Token: ctx.Query("token")})
+}
+
+func renderAccountPage(ctx *gin.Context, page accountPage) {
+	// 链接中的令牌不缓存、不通过Referer泄露
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Referrer-Policy", "no-referrer")
+	ctx.Status(http.StatusOK)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	if err := accountTemplate.Execute(ctx.Writer, page); err != nil {
+		_ = ctx.Error(err)
+	}
+}
+
+// accountTemplate 重置密码和验证邮箱页面模板，不依赖前端构建产物
+// 验证邮箱由脚本提交，避免邮件客户端预取链接时误触发
+var accountTemplate = template.Must(template.New("account").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if eq .Action "resetpassword"}}重置密码{{else}}验证邮箱{{end}}</title>
+<style>
+body { margin: 0; background: #f7f7f8; color: #343541; font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; }
+main { max-width: 360px; margin: 80px auto; padding: 24px; background: #fff; border-radius: 8px; box-shadow: 0 1px 2px rgba(0, 0, 0, .06); }
+h1 { font-size: 20px; margin: 0 0 16px; }
+input, button { box-sizing: border-box; width: 100%; font-size: 14px; padding: 8px 12px; margin-bottom: 12px; border-radius: 6px; border: 1px solid #d9d9e3; }
+button { background: #10a37f; color: #fff; border-color: #10a37f; cursor: pointer; }
+.message { margin: 0 0 12px; }
+.error { color: #d93025; }
+a { color: #10a37f; }
+</style>
+</head>
+<body>
+<main>
+{{if eq .Action "resetpassword"}}
+<h1>重置密码</h1>
+<form id="form">
+<input type="password" id="password" placeholder="新密码，至少8位，包含大小写字母、数字" autocomplete="new-password" required>
+<input type="password" id="confirm" placeholder="确认新密码" autocomplete="new-password" required>
+<button type="submit">重置密码</button>
+</form>
+{{else}}
+<h1>验证邮箱</h1>
+{{end}}
+<p class="message" id="message"></p>
+<p><a href="/" id="home" hidden>返回登录</a></p>
+</main>
+<script>
+(function () {
+  var token = {{.Token}};
+  var message = document.getElementById("message");
+  function show(text, failed) {
+    message.textContent = text;
+    message.className = failed ? "message error" : "message";
+  }
+  function submit(data, done) {
+    data.token = token;
+    fetch("/user/{{.Action}}", {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify(data)
+    }).then(function (res) { return res.json(); }).then(function (res) {
+      if (res.code === 200) {
+        show(done, false);
+        document.getElementById("home").hidden = false;
+      } else {
+        show(res.errorMsg, true);
+      }
+    }).catch(function () { show("请求失败，请稍后再试", true); });
+  }
+  if (!token) {
+    show("链接无效，请重新获取邮件", true);
+    return;
+  }
+{{if eq .Action "resetpassword"}}
+  document.getElementById("form").addEventListener("submit", function (event) {
+    event.preventDefault();
+    var password = document.getElementById("password").value;
+    if (password !== document.getElementById("confirm").value) {
+      show("两次输入的密码不一致", true);
+      return;
+    }
+    submit({newpassword: password}, "密码已重置，请使用新密码登录");
+  });
+{{else}}
+  show("正在验证……", false);
+  submit({}, "邮箱验证成功");
+{{end}}
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/app"
+	"github.com/869413421/chatgpt-web/pkg/model/prompt"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/869413421/chatgpt-web/pkg/tools"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// appKey 本次请求使用的应用
+const appKey = "app"
+
+// maxToolRounds 一次回复中模型最多调用工具的轮数
+const maxToolRounds = 5
+
+var (
+	appKeyPattern    = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+	fieldNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]{1,32}$`)
+)
+
+// AppController 应用控制器
+type AppController struct {
+	BaseController
+}
+
+func NewAppController() *AppController {
+	return &AppController{}
+}
+
+// appRequest 管理员保存应用的请求
+type appRequest struct {
+	ID           uint64      `json:"id"`
+	Key          string      `json:"key"`
+	Name         string      `json:"name"`
+	Description  string      `json:"description"`
+	SystemPrompt string      `json:"system_prompt"`
+	Template     string      `json:"template"`
+	Fields       []app.Field `json:"fields"`
+	Tools        []string    `json:"tools"`
+	GroupIDs     []uint64    `json:"groupids"`
+	Enabled      bool        `json:"enabled"`
+	paramsRequest
+}
+
+// appRunRequest 运行应用的请求
+type appRunRequest struct {
+	Values map[string]string `json:"values"`
+	Stream bool              `json:"stream"`
+}
+
+// List 当前用户可以使用的应用，不返回系统提示词等内部设置
+func (c *AppController) List(ctx *gin.Context) {
+	current, ok := c.currentUser(ctx)
+	if !ok {
+		return
+	}
+	apps, err := app.List(true)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	result := make([]gin.H, 0, len(apps))
+	for _, item := range apps {
+		if !current.IsAdmin && !item.AllowGroup(current.GroupID) {
+			continue
+		}
+		result = append(result, gin.H{
+			"ID":          item.ID,
+			"Key":         item.Key,
+			"Name":        item.Name,
+			"Description": item.Description,
+			"Fields":      item.Fields,
+		})
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Apps": result,
+	})
+}
+
+// Run 填写表单后运行应用，每次运行相互独立，不保存为会话
+func (c *AppController) Run(ctx *gin.Context) {
+	var req appRunRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if req.Stream {
+		requestStream(ctx)
+	}
+	current, ok := c.currentUser(ctx)
+	if !ok {
+		return
+	}
+	item, err := app.GetByKey(ctx.Param("key"))
+	if err != nil || (!current.IsAdmin && (!item.Enabled || !item.AllowGroup(current.GroupID))) {
+		c.ResponseJson(ctx, customErrorCode, "应用不存在或无权使用", nil)
+		return
+	}
+	values, err := item.CheckValues(req.Values)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	content, err := appMessage(item, values)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	// 系统提示词、模型及参数由应用固定，不使用用户和会话的设置
+	ctx.Set(appKey, item)
+	request := gogpt.ChatCompletionRequest{
+		Messages: []gogpt.ChatCompletionMessage{{Role: gogpt.ChatMessageRoleUser, Content: content}},
+	}
+	applyParams(&request, item.Params.Merge(globalParams()))
+	result, err := completeWithTools(ctx, request, "", usage.KindApp, item.Tools)
+	if err != nil {
+		c.responseError(ctx, err)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Reply":      result.Message.Content,
+		"Incomplete": isTruncated(result),
+		"Warnings":   quotaWarnings(ctx),
+	})
+}
+
+// All 管理员获取全部应用
+func (c *AppController) All(ctx *gin.Context) {
+	apps, err := app.List(false)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Apps":  apps,
+		"Tools": tools.Names(),
+	})
+}
+
+// Save 新建或修改应用
+func (c *AppController) Save(ctx *gin.Context) {
+	var req appRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if err = req.check(); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	params, err := req.toParams()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if exists, err := app.GetByKey(req.Key); err == nil && exists.ID != req.ID {
+		c.ResponseJson(ctx, customErrorCode, "应用标识已被使用", nil)
+		return
+	}
+
+	item := &app.App{}
+	if req.ID != 0 {
+		if item, err = app.Get(req.ID); err != nil {
+			c.ResponseJson(ctx, customErrorCode, "应用不存在", nil)
+			return
+		}
+	}
+	item.Key = req.Key
+	item.Name = req.Name
+	item.Description = req.Description
+	item.SystemPrompt = req.SystemPrompt
+	item.Template = req.Template
+	item.Fields = req.Fields
+	item.Tools = req.Tools
+	item.GroupIDs = req.GroupIDs
+	item.Enabled = req.Enabled
+	item.Params = params
+	if err = app.Save(item); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"App": item,
+	})
+}
+
+// Delete 删除应用，已有的用量记录保留
+func (c *AppController) Delete(ctx *gin.Context) {
+	var req appRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if err = app.Delete(req.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// check 校验应用设置
+func (r *appRequest) check() error {
+	r.Key = strings.TrimSpace(r.Key)
+	r.Name = strings.TrimSpace(r.Name)
+	r.SystemPrompt = strings.TrimSpace(r.SystemPrompt)
+	if !appKeyPattern.MatchString(r.Key) {
+		return fmt.Errorf("应用标识只能包含小写字母、数字、下划线和横线，且不能超过64个字符")
+	}
+	if r.Name == "" || utf8.RuneCountInString(r.Name) > 64 {
+		return fmt.Errorf("应用名称不能为空且不能超过64个字")
+	}
+	if r.SystemPrompt == "" {
+		return fmt.Errorf("系统提示词不能为空")
+	}
+	if len(r.Fields) == 0 {
+		return fmt.Errorf("至少需要一个表单字段")
+	}
+	seen := make(map[string]bool, len(r.Fields))
+	for i := range r.Fields {
+		field := &r.Fields[i]
+		if !fieldNamePattern.MatchString(field.Name) || seen[field.Name] {
+			return fmt.Errorf("字段名称不正确或重复：%s", field.Name)
+		}
+		seen[field.Name] = true
+		if field.Label == "" {
+			field.Label = field.Name
+		}
+		switch field.Type {
+		case "":
+			field.Type = app.FieldText
+		case app.FieldText, app.FieldTextarea:
+		case app.FieldSelect:
+			if len(field.Options) == 0 {
+				return fmt.Errorf("下拉字段%s需要设置选项", field.Label)
+			}
+		default:
+			return fmt.Errorf("不支持的字段类型：%s", field.Type)
+		}
+	}
+	if _, err := tools.Definitions(r.Tools); err != nil {
+		return err
+	}
+	// 模板中只能使用已定义的字段
+	template := prompt.Template{Content: r.Template}
+	for _, name := range template.Variables() {
+		if !seen[name] {
+			return fmt.Errorf("模板中的变量%s不是表单字段", name)
+		}
+	}
+	if r.Tools == nil {
+		r.Tools = []string{}
+	}
+	if r.GroupIDs == nil {
+		r.GroupIDs = []uint64{}
+	}
+	return nil
+}
+
+// currentUser 重新读取登录用户，以便使用最新的用户组，失败时直接返回错误响应
+func (c *AppController) currentUser(ctx *gin.Context) (*user.User, bool) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return nil, false
+	}
+	current, err := user.GetByID(userInfo.ID)
+	if err != nil {
+		logger.Warning("load user error:", err)
+		current = userInfo
+	}
+	return current, true
+}
+
+// appMessage 根据应用模板生成用户消息，没有模板时按字段顺序拼接
+func appMessage(item *app.App, values map[string]string) (string, error) {
+	if item.Template != "" {
+		template := prompt.Template{Content: item.Template}
+		return template.Render(values)
+	}
+	var builder strings.Builder
+	for _, field := range item.Fields {
+		if values[field.Name] != "" {
+			builder.WriteString(fmt.Sprintf("%s：%s\n", field.Label, values[field.Name]))
+		}
+	}
+	return strings.TrimSpace(builder.String()), nil
+}
+
+// currentApp 本次请求使用的应用，没有时返回nil
+func currentApp(ctx *gin.Context) *app.App {
+	if item, ok := ctx.Get(appKey); ok {
+		return item.(*app.App)
+	}
+	return nil
+}
+
+// completeWithTools 允许模型调用指定的工具，执行工具后把结果发回模型，直到模型给出回复
+// 返回的用量为各轮之和，达到maxToolRounds后不再提供工具，要求模型直接回答
+func completeWithTools(ctx *gin.Context, request gogpt.ChatCompletionRequest, chatID string, kind string, names []string) (*completionResult, error) {
+	if len(names) == 0 {
+		return complete(ctx, request, chatID, kind)
+	}
+	definitions, err := tools.Definitions(names)
+	if err != nil {
+		return nil, err
+	}
+	request.Tools = definitions
+
+	var total gogpt.Usage
+	for i := 0; i <= maxToolRounds; i++ {
+		if i == maxToolRounds {
+			request.Tools = nil
+		}
+		result, err := complete(ctx, request, chatID, kind)
+		if err != nil {
+			return nil, err
+		}
+		total.PromptTokens += result.Usage.PromptTokens
+		total.CompletionTokens += result.Usage.CompletionTokens
+		total.TotalTokens += result.Usage.TotalTokens
+		if len(result.Message.ToolCalls) == 0 {
+			result.Usage = total
+			return result, nil
+		}
+
+		request.Messages = append(request.Messages, result.Message)
+		for _, call := range result.Message.ToolCalls {
+			request.Messages = append(request.Messages, gogpt.ChatCompletionMessage{
+				Role:       gogpt.ChatMessageRoleTool,
+				Content:    tools.Call(ctx.Request.Context(), call),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+	return nil, fmt.Errorf("模型调用工具的次数过多")
+}
@@ -71,11 +71,18 @@ func (c *AuthController) Info(ctx *gin.Context) {
 		c.ResponseJson(ctx, http.StatusInternalServerError, "断言登录用户信息失败", nil)
 		return
 	}
+	profile, err := user.GetProfile(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
 	// 未实现权限系统，写死
 	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
-		"info": userInfo,
+		"info":    userInfo,
+		"profile": profile,
 		"permissionRoutes": []string{"chat", "chat/completion", "chat/userchatrecord",
 			"chat/renamesubject", "chat/deletechat", "chat/getconfig", "chat/setconfig",
-			"user/updatepassword", "user/createuser", "user/auth/info", "user/auth"},
+			"user/updatepassword", "user/createuser", "user/updateemail", "user/sendverifyemail",
+			"user/profile", "user/saveprofile", "user/auth/info", "user/auth"},
 	})
 }
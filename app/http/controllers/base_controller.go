@@ -7,12 +7,24 @@ import (
 type BaseController struct {
 }
 
-func (*BaseController) ResponseJson(ctx *gin.Context, code int, errorMsg string, data interface{}) {
+// streamRequestKey 标记请求希望以SSE方式响应
+const streamRequestKey = "stream_request"
+
+// streamKey 标记请求已开始以SSE方式响应
+const streamKey = "stream"
 
-	ctx.JSON(code, gin.H{
+func (*BaseController) ResponseJson(ctx *gin.Context, code int, errorMsg string, data interface{}) {
+	body := gin.H{
 		"code":     code,
 		"errorMsg": errorMsg,
 		"data":     data,
-	})
+	}
+	// 流式请求已经开始推送事件，结果以done事件返回
+	if ctx.GetBool(streamKey) {
+		ctx.SSEvent("done", body)
+		ctx.Writer.Flush()
+	} else {
+		ctx.JSON(code, body)
+	}
 	ctx.Abort()
 }
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// EditMessage 修改之前的用户消息，从该处产生新的分支并重新回复，原有分支保留
+func (c *ChatController) EditMessage(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if request.Stream {
+		requestStream(ctx)
+	}
+	userInfo, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	request.UserID = userInfo.ID
+	if strings.TrimSpace(request.Content) == "" {
+		c.ResponseJson(ctx, customErrorCode, "需要输入问话内容", nil)
+		return
+	}
+
+	message, err := chat.SelectMessage(chatRecord, request.MessageID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if message.Role != gogpt.ChatMessageRoleUser {
+		c.ResponseJson(ctx, customErrorCode, "只能修改用户的消息", nil)
+		return
+	}
+	history, err := chat.SelectPathTo(chatRecord, message.ParentID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	newMessages := []gogpt.ChatCompletionMessage{{Role: gogpt.ChatMessageRoleUser, Content: request.Content}}
+	c.completeTurn(ctx, &request, userInfo, chatRecord, history, newMessages, message.ParentID)
+}
+
+// Regenerate 重新生成回复，可指定其他模型或temperature
+// 新回复作为原回复的兄弟分支保存并设为当前分支，可通过switchbranch选择后续对话使用哪一个
+func (c *ChatController) Regenerate(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if request.Stream {
+		requestStream(ctx)
+	}
+	userInfo, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	request.UserID = userInfo.ID
+
+	// 未指定消息时重新生成当前分支最后一条回复，指定回复时找到其对应的用户消息
+	messageId := request.MessageID
+	if messageId == 0 {
+		messageId = chatRecord.LeafID
+	}
+	message, err := chat.SelectMessage(chatRecord, messageId)
+	if err == nil && message.Role != gogpt.ChatMessageRoleUser {
+		message, err = chat.SelectMessage(chatRecord, message.ParentID)
+	}
+	if err != nil || message.Role != gogpt.ChatMessageRoleUser {
+		c.ResponseJson(ctx, customErrorCode, "没有可以重新生成的回复", nil)
+		return
+	}
+	history, err := chat.SelectPathTo(chatRecord, message.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	c.completeTurn(ctx, &request, userInfo, chatRecord, history, nil, message.ID)
+}
+
+// Siblings 获取指定消息的全部兄弟分支
+func (c *ChatController) Siblings(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+
+	siblings, err := chat.SelectSiblings(chatRecord, request.MessageID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	index := 0
+	for i, item := range siblings {
+		if item.ID == request.MessageID {
+			index = i
+		}
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Index":    index,
+		"Messages": messagesView(siblings, nil),
+	})
+}
+
+// SwitchBranch 切换当前使用的分支，之后的对话以该分支作为上下文
+func (c *ChatController) SwitchBranch(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+
+	if err = chat.SwitchBranch(chatRecord, request.MessageID); err != nil {
+		c.responseError(ctx, err)
+		return
+	}
+	path, children, err := chat.SelectTree(chatRecord)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Messages": messagesView(path, children),
+	})
+}
+
+// ownChatRecord 查询当前登录用户的会话，失败时直接返回错误响应
+func (c *ChatController) ownChatRecord(ctx *gin.Context, chatId string) (*user.User, *chat.Record, bool) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return nil, nil, false
+	}
+	chatRecord, err := chat.SelectRecordByChatId(chatId)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, "会话不存在", nil)
+		return nil, nil, false
+	}
+	if chatRecord.UserID != userInfo.ID {
+		c.ResponseJson(ctx, customErrorCode, "不是当前登录用户的会话记录", nil)
+		return nil, nil, false
+	}
+	useChatPersona(ctx, chatRecord)
+	return userInfo, chatRecord, true
+}
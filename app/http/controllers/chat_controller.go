@@ -3,12 +3,12 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"reflect"
 	"strings"
 	"time"
 
@@ -17,13 +17,14 @@ import (
 	"github.com/869413421/chatgpt-web/config"
 	"github.com/869413421/chatgpt-web/pkg/logger"
 	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
 	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
 	"github.com/gin-gonic/gin"
 	gogpt "github.com/sashabaranov/go-openai"
 )
 
 const customErrorCode = 280
-const subjectMaxLength = 15
 
 // ChatController 首页控制器
 type ChatController struct {
@@ -31,10 +32,25 @@ type ChatController struct {
 }
 
 type ChatRequest struct {
-	UserID  uint64 `json:"userid"`
-	ChatID  string `json:"chatid"`
-	Subject string `json:"subject"`
+	UserID    uint64 `json:"userid"`
+	ChatID    string `json:"chatid"`
+	Subject   string `json:"subject"`
+	MessageID uint64 `json:"messageid"`
+	Content   string `json:"content"`
+	// PersonaID 新会话使用的角色
+	PersonaID uint64 `json:"personaid"`
 	gogpt.ChatCompletionRequest
+	// sampling 请求中的采样参数，以指针区分未传和传0
+	sampling paramsRequest
+}
+
+// UnmarshalJSON 解析请求，并记录请求中传了哪些采样参数
+func (r *ChatRequest) UnmarshalJSON(data []byte) error {
+	type chatRequest ChatRequest
+	if err := json.Unmarshal(data, (*chatRequest)(r)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &r.sampling)
 }
 
 // NewChatController 创建控制器
@@ -127,6 +143,22 @@ func (c *ChatController) SetConfig(ctx *gin.Context) {
 	c.ResponseJson(ctx, http.StatusOK, "", nil)
 }
 
+// maxRecordPageSize 会话列表每页最多返回的会话数
+const maxRecordPageSize = 100
+
+// recordListRequest 会话列表的筛选及分页条件，不传请求体时返回第一页未归档的会话
+// limit为0时每页50个，以上一页返回的NextCursor作为cursor获取下一页
+type recordListRequest struct {
+	FolderID *uint64 `json:"folderid"`
+	Tag      string  `json:"tag"`
+	Pinned   *bool   `json:"pinned"`
+	Archived bool    `json:"archived"`
+	Keyword  string  `json:"keyword"`
+	Limit    int     `json:"limit"`
+	Cursor   string  `json:"cursor"`
+	Sort     string  `json:"sort"`
+}
+
 // UserChatRecord 获取当前登录用户的聊天记录
 func (c *ChatController) UserChatRecord(ctx *gin.Context) {
 	userInfo := GetLoginUser(ctx)
@@ -134,20 +166,38 @@ func (c *ChatController) UserChatRecord(ctx *gin.Context) {
 		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
 		return
 	} else {
-		records, err := chat.SelectRecordByUserId(userInfo.ID)
+		var request recordListRequest
+		if ctx.Request.ContentLength > 0 {
+			if err := ctx.BindJSON(&request); err != nil {
+				c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+				return
+			}
+		}
+		if request.Limit < 0 || request.Limit > maxRecordPageSize {
+			c.ResponseJson(ctx, customErrorCode, fmt.Sprintf("每页数量不能超过%d", maxRecordPageSize), nil)
+			return
+		}
+		if request.Sort != "" && request.Sort != chat.SortUpdated && request.Sort != chat.SortCreated {
+			c.ResponseJson(ctx, customErrorCode, "排序方式只能是updated或created", nil)
+			return
+		}
+		records, next, err := chat.SelectRecords(userInfo.ID, chat.RecordFilter{
+			FolderID: request.FolderID,
+			Tag:      strings.TrimSpace(request.Tag),
+			Pinned:   request.Pinned,
+			Archived: request.Archived,
+			Keyword:  strings.TrimSpace(request.Keyword),
+		}, chat.RecordPage{Limit: request.Limit, Cursor: request.Cursor, Sort: request.Sort})
 		if err != nil {
 			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
 			return
 		} else {
-			var chatRecord []gin.H
-			for _, item := range records {
-				chatRecord = append(chatRecord, gin.H{"ID": item.ID, "Subject": item.Subject, "ChatID": item.ChatID, "CreatedAt": item.CreatedAt, "UpdatedAt": item.UpdatedAt})
-			}
 			c.ResponseJson(ctx, http.StatusOK, "", gin.H{
 				"UserID":     userInfo.ID,
 				"UserName":   userInfo.Name,
 				"IsAdmin":    userInfo.IsAdmin,
-				"ChatRecord": chatRecord,
+				"ChatRecord": recordsView(records),
+				"NextCursor": next,
 			})
 			return
 		}
@@ -174,12 +224,35 @@ func (c *ChatController) ChatMessages(ctx *gin.Context) {
 		} else if userInfo.ID != record.UserID {
 			c.ResponseJson(ctx, customErrorCode, "不是当前登录用户的会话记录", nil)
 			return
-		} else {
-			c.ResponseJson(ctx, http.StatusOK, "", gin.H{
-				"messages": record.Messages,
-			})
+		}
+		path, children, err := chat.SelectTree(record)
+		if err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+			return
+		}
+		// 保持与旧版本一致，以JSON字符串返回消息列表
+		view := messagesView(path, children)
+		migrateError := ""
+		if record.MigrateFailed() {
+			// 旧版本的消息无法迁移时原样展示，避免看起来像是空会话
+			migrateError = chat.ErrMigrateFailed.Error()
+			view = append([]gin.H{{
+				"id":         0,
+				"parent_id":  0,
+				"role":       gogpt.ChatMessageRoleSystem,
+				"content":    migrateError + "，以下为原始数据：\n" + record.Messages,
+				"created_at": record.CreatedAt,
+			}}, view...)
+		}
+		messagesJson, err := json.Marshal(view)
+		if err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
 			return
 		}
+		c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+			"messages":      string(messagesJson),
+			"migrate_error": migrateError,
+		})
 	}
 }
 
@@ -239,80 +312,208 @@ func (c *ChatController) Completion(ctx *gin.Context) {
 		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
 		return
 	}
+	// 流式请求以SSE推送排队位置和最终结果
+	if request.Stream {
+		requestStream(ctx)
+	}
+	c.completion(ctx, &request)
+}
+
+// completion 发送请求中的消息，没有会话时创建会话
+func (c *ChatController) completion(ctx *gin.Context, request *ChatRequest) {
 	// 强制设置用户ID
 	userInfo := GetLoginUser(ctx)
 	if userInfo != nil {
 		request.UserID = userInfo.ID
 	}
-	chatRecord, err := chat.SelectRecordByChatId(request.ChatID)
-	// 如果没有聊天记录，就创建一个
-	if err != nil {
-		// 使用第一条消息作为问题自动获取关键信息作为主题
-		var req gogpt.ChatCompletionRequest
-		req.Messages = append(req.Messages, request.Messages[0])
-		req.Messages[0].Content = fmt.Sprintf("从“%s”这段文字中提验%d字内的关键信息", req.Messages[0].Content, subjectMaxLength)
-		resp, err := CreateChatCompletion(ctx, req)
-		if err != nil {
-			request.Subject = request.Messages[0].Content
-		} else {
-			// 判断resp是不是gogpt.ChatCompletionResponse类型
-			if reflect.TypeOf(resp) == reflect.TypeOf(gogpt.ChatCompletionResponse{}) {
-				request.Subject = resp.(gogpt.ChatCompletionResponse).Choices[0].Message.Content
-			} else {
-				request.Subject = resp.(gogpt.CompletionResponse).Choices[0].Text
-			}
-		}
-	} else {
-		chatMessage := request.Messages[0]
-		json.Unmarshal([]byte(chatRecord.Messages), &request.Messages)
-
-		request.Messages = append(request.Messages, chatMessage)
-	}
-	logger.Info(request)
 	if len(request.Messages) == 0 {
 		c.ResponseJson(ctx, customErrorCode, "需要输入问话内容", nil)
 		return
 	}
 
-	// 调用GPT3生成回复
-	resp, err := CreateChatCompletion(ctx, request.ChatCompletionRequest)
+	var history []*chat.Message
+	newMessages := request.Messages
+	chatRecord, err := chat.SelectRecordByChatId(request.ChatID)
+	// 如果没有聊天记录，回复成功后再创建，先以第一条消息作为主题，回复返回后再生成主题
 	if err != nil {
-		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
-		return
-	} else {
-		var newMessage gogpt.ChatCompletionMessage
-		// 判断resp是不是gogpt.ChatCompletionResponse类型
-		if reflect.TypeOf(resp) == reflect.TypeOf(gogpt.ChatCompletionResponse{}) {
-			newMessage = resp.(gogpt.ChatCompletionResponse).Choices[0].Message
-		} else {
-			newMessage = gogpt.ChatCompletionMessage{Role: "assistant", Content: resp.(gogpt.CompletionResponse).Choices[0].Text}
-		}
-		newMessagesJson, err := json.Marshal(append(request.Messages, newMessage))
-		if err != nil {
+		chatRecord = nil
+		request.Subject = fallbackSubject(request.Messages[0].Content)
+		if err = usePersona(ctx, request.PersonaID); err != nil {
 			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
 			return
 		}
-		item, err := chat.UpdateRecord(request.UserID, request.ChatID, request.Subject, string(newMessagesJson))
+	} else if chatRecord.UserID != request.UserID {
+		c.ResponseJson(ctx, customErrorCode, "不是当前登录用户的会话记录", nil)
+		return
+	} else {
+		useChatPersona(ctx, chatRecord)
+		history, err = chat.SelectPath(chatRecord)
 		if err != nil {
 			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
 			return
 		}
+		newMessages = request.Messages[:1]
+	}
 
-		var chatRecord []gin.H
-		chatRecord = append(chatRecord, gin.H{"ID": item.ID, "Subject": item.Subject, "ChatID": item.ChatID, "CreatedAt": item.CreatedAt, "UpdatedAt": item.UpdatedAt})
-		c.ResponseJson(ctx, http.StatusOK, "", gin.H{
-			"Reply":      newMessage.Content,
-			"UserID":     userInfo.ID,
-			"UserName":   userInfo.Name,
-			"ChatRecord": chatRecord,
+	var parentId uint64
+	if chatRecord != nil {
+		parentId = chatRecord.LeafID
+	}
+	saved := c.completeTurn(ctx, request, userInfo, chatRecord, history, newMessages, parentId)
+	if chatRecord == nil && saved != nil {
+		generateSubjectAfterReply(ctx, saved, newMessages[0].Content)
+	}
+}
+
+// completeTurn 以history为上下文发送newMessages，把新消息和回复追加到parentId之后并返回结果
+// chatRecord为nil时在回复成功后创建会话，保存成功时返回会话，失败时已返回错误响应
+func (c *ChatController) completeTurn(ctx *gin.Context, request *ChatRequest, userInfo *user.User, chatRecord *chat.Record,
+	history []*chat.Message, newMessages []gogpt.ChatCompletionMessage, parentId uint64) *chat.Record {
+	// 新会话保存创建时指定的模型及参数，之后按请求、会话、角色、用户、用户组、全局的顺序确定参数
+	requested := paramsFromRequest(request)
+	applyParams(&request.ChatCompletionRequest, resolveParams(ctx, requested, chatRecord))
+
+	// 历史过长时丢弃最早的对话，保证不超出模型的上下文窗口，并为自动继续时追加的回复预留空间
+	// 开启摘要时被丢弃的对话以摘要代替
+	autoContinue := config.LoadConfig().AutoContinue
+	var dropped []*chat.Message
+	var summary string
+	request.Messages, dropped, summary = fitMessages(ctx, request.ChatCompletionRequest, chatRecord, history, newMessages,
+		continueReserve(request.ChatCompletionRequest, replyMaxTokens(request.ChatCompletionRequest), autoContinue))
+	logger.Info(request)
+
+	// 调用GPT3生成回复
+	result, err := complete(ctx, request.ChatCompletionRequest, request.ChatID, usage.KindChat)
+	if err != nil {
+		c.responseError(ctx, err)
+		return nil
+	}
+	// 回复被截断时按配置自动继续，续写失败时保留已有内容，由用户手动继续
+	result, err = continueReply(ctx, request.ChatCompletionRequest, request.ChatID, result, autoContinue)
+	if err != nil {
+		logger.Warning("auto continue error:", err)
+	}
+
+	var saveMessages []*chat.Message
+	for _, item := range newMessages {
+		saveMessages = append(saveMessages, &chat.Message{Role: item.Role, Content: item.Content, Tokens: tokenizer.Count(result.Model, item.Content)})
+	}
+	reply := &chat.Message{
+		Role:         result.Message.Role,
+		Content:      result.Message.Content,
+		Model:        result.Model,
+		Tokens:       result.Usage.CompletionTokens,
+		PromptTokens: result.Usage.PromptTokens,
+		Incomplete:   isTruncated(result),
+	}
+	saveMessages = append(saveMessages, reply)
+	if chatRecord == nil {
+		chatRecord = &chat.Record{UserID: request.UserID, ChatID: request.ChatID, Subject: request.Subject, PersonaID: request.PersonaID, Params: requested}
+		err = chat.CreateRecord(chatRecord)
+	}
+	if err == nil {
+		err = chat.AppendMessagesTo(chatRecord, parentId, saveMessages...)
+	}
+	// 会话在生成回复期间被其他窗口创建或追加了消息时不覆盖，把回复返回给客户端由用户决定是否重发
+	if errors.Is(err, chat.ErrConflict) {
+		c.ResponseJson(ctx, http.StatusConflict, err.Error(), gin.H{"Reply": result.Message.Content})
+		return nil
+	}
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return nil
+	}
+
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Reply":        result.Message.Content,
+		"MessageID":    reply.ID,
+		"ParentID":     reply.ParentID,
+		"Incomplete":   reply.Incomplete,
+		"TurnsSent":    countTurns(request.Messages),
+		"TurnsDropped": countTurns(toCompletionMessages(dropped)),
+		"Summarized":   summary != "",
+		"UserID":       userInfo.ID,
+		"UserName":     userInfo.Name,
+		"ChatRecord":   recordsView([]*chat.Record{chatRecord}),
+		"Warnings":     quotaWarnings(ctx),
+	})
+	return chatRecord
+}
+
+// recordsView 返回给前端的会话列表，附带每个会话的标签、消息数及最后一条消息的摘录
+func recordsView(records []*chat.Record) []gin.H {
+	ids := make([]uint64, 0, len(records))
+	for _, item := range records {
+		ids = append(ids, item.ID)
+	}
+	tags, err := chat.SelectTags(ids)
+	if err != nil {
+		logger.Warning("load chat tags error:", err)
+	}
+	stats, err := chat.SelectRecordStats(records)
+	if err != nil {
+		logger.Warning("load chat stats error:", err)
+	}
+
+	var result []gin.H
+	for _, item := range records {
+		summary := stats[item.ID]
+		if summary == nil {
+			summary = &chat.RecordStats{Model: item.Model}
+		}
+		result = append(result, gin.H{
+			"ID":           item.ID,
+			"Subject":      item.Subject,
+			"ChatID":       item.ChatID,
+			"PersonaID":    item.PersonaID,
+			"FolderID":     item.FolderID,
+			"Tags":         tags[item.ID],
+			"Pinned":       item.Pinned,
+			"Archived":     item.Archived,
+			"MessageCount": summary.MessageCount,
+			"Snippet":      summary.Snippet,
+			"Model":        summary.Model,
+			"CreatedAt":    item.CreatedAt,
+			"UpdatedAt":    item.UpdatedAt,
 		})
 	}
+	return result
 }
 
-// CreateChatCompletion 创建聊天回复
-func CreateChatCompletion(ctx *gin.Context, request gogpt.ChatCompletionRequest) (any, error) {
-	cnf := config.LoadConfig()
+// toCompletionMessages 将保存的消息转换为请求上游的消息
+func toCompletionMessages(messages []*chat.Message) []gogpt.ChatCompletionMessage {
+	result := make([]gogpt.ChatCompletionMessage, 0, len(messages))
+	for _, item := range messages {
+		result = append(result, gogpt.ChatCompletionMessage{Role: item.Role, Content: item.Content})
+	}
+	return result
+}
+
+// messagesView 返回给前端的消息列表
+// children不为空时附带每条消息的兄弟分支ID，便于前端展示分支切换
+func messagesView(messages []*chat.Message, children map[uint64][]uint64) []gin.H {
+	result := make([]gin.H, 0, len(messages))
+	for _, item := range messages {
+		view := gin.H{
+			"id":         item.ID,
+			"parent_id":  item.ParentID,
+			"role":       item.Role,
+			"content":    item.Content,
+			"model":      item.Model,
+			"tokens":     item.Tokens,
+			"incomplete": item.Incomplete,
+			"created_at": item.CreatedAt,
+		}
+		if children != nil {
+			view["siblings"] = children[item.ParentID]
+		}
+		result = append(result, view)
+	}
+	return result
+}
 
+// newClient 按配置创建OpenAI客户端，支持Azure和代理
+func newClient(cnf *config.Configuration) *gogpt.Client {
 	var gptConfig gogpt.ClientConfig
 	// 通过配置文件中的ApiURL判断是否是Azure API
 	if cnf.ApiURL != "" && strings.Contains(cnf.ApiURL, "openai.azure.com") {
@@ -355,39 +556,77 @@ func CreateChatCompletion(ctx *gin.Context, request gogpt.ChatCompletionRequest)
 		gptConfig.APIVersion = cnf.ApiVersion
 	}
 
-	client := gogpt.NewClientWithConfig(gptConfig)
-	// 如果第一条消息不是系统消息，就添加一条系统消息
-	if request.Messages[0].Role != "system" {
-		newMessage := append([]gogpt.ChatCompletionMessage{
-			{Role: "system", Content: cnf.BotDesc},
-		}, request.Messages...)
-		request.Messages = newMessage
+	return gogpt.NewClientWithConfig(gptConfig)
+}
+
+// withSystemPrompt 如果第一条消息不是系统消息，就添加一条系统消息，返回实际发送给上游的消息
+func withSystemPrompt(ctx *gin.Context, messages []gogpt.ChatCompletionMessage) []gogpt.ChatCompletionMessage {
+	if len(messages) > 0 && messages[0].Role == "system" {
+		return messages
 	}
+	return append([]gogpt.ChatCompletionMessage{
+		{Role: "system", Content: systemPrompt(ctx)},
+	}, messages...)
+}
 
-	if !elementExists[string](cnf.Model, []string{
+// isCompletionModel 是否是只支持completion接口的旧模型，这些模型的消息拼接为一段提示词发送
+func isCompletionModel(model string) bool {
+	return elementExists[string](model, []string{
 		gogpt.CodexCodeDavinci002, gogpt.CodexCodeCushman001, gogpt.CodexCodeDavinci001,
 		gogpt.GPT3TextDavinci003, gogpt.GPT3TextDavinci002, gogpt.GPT3TextCurie001,
 		gogpt.GPT3TextBabbage001, gogpt.GPT3TextAda001, gogpt.GPT3TextDavinci001,
 		gogpt.GPT3DavinciInstructBeta, gogpt.GPT3Davinci, gogpt.GPT3CurieInstructBeta,
-		gogpt.GPT3Curie, gogpt.GPT3Ada, gogpt.GPT3Babbage}) {
+		gogpt.GPT3Curie, gogpt.GPT3Ada, gogpt.GPT3Babbage})
+}
+
+// completionPrompt 将消息拼接为completion接口的提示词
+func completionPrompt(messages []gogpt.ChatCompletionMessage) string {
+	prompt := ""
+	for _, item := range messages {
+		prompt += item.Content + "/n"
+	}
+	return strings.Trim(prompt, "/n")
+}
+
+// CreateChatCompletion 创建聊天回复
+func CreateChatCompletion(ctx *gin.Context, request gogpt.ChatCompletionRequest) (any, error) {
+	cnf := config.LoadConfig()
+
+	client := newClient(cnf)
+	request.Messages = withSystemPrompt(ctx, request.Messages)
+
+	if request.Model == "" {
 		request.Model = cnf.Model
+	}
+
+	if !isCompletionModel(request.Model) {
 		return client.CreateChatCompletion(ctx, request)
 	} else {
-		prompt := ""
-		for _, item := range request.Messages {
-			prompt += item.Content + "/n"
-		}
-		prompt = strings.Trim(prompt, "/n")
+		prompt := completionPrompt(request.Messages)
 
 		logger.Info("request prompt is", prompt)
 		req := gogpt.CompletionRequest{
-			Model:            cnf.Model,
+			Model:            request.Model,
 			MaxTokens:        cnf.MaxTokens,
+			Temperature:      request.Temperature,
 			TopP:             cnf.TopP,
 			FrequencyPenalty: cnf.FrequencyPenalty,
 			PresencePenalty:  cnf.PresencePenalty,
 			Prompt:           prompt,
 		}
+		// 聊天接口已按优先级确定了参数，这里沿用
+		if request.MaxTokens > 0 {
+			req.MaxTokens = request.MaxTokens
+		}
+		if request.TopP != 0 {
+			req.TopP = request.TopP
+		}
+		if request.FrequencyPenalty != 0 {
+			req.FrequencyPenalty = request.FrequencyPenalty
+		}
+		if request.PresencePenalty != 0 {
+			req.PresencePenalty = request.PresencePenalty
+		}
 		return client.CreateCompletion(ctx, req)
 	}
 }
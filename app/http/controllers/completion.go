@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/queue"
+	"github.com/869413421/chatgpt-web/pkg/ratelimit"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// responseError 根据错误类型返回对应的状态码，被限流时返回429及Retry-After，会话冲突时返回409
+func (c *BaseController) responseError(ctx *gin.Context, err error) {
+	if errors.Is(err, chat.ErrConflict) {
+		c.ResponseJson(ctx, http.StatusConflict, err.Error(), nil)
+		return
+	}
+	var limited *ratelimit.LimitedError
+	if errors.As(err, &limited) {
+		ctx.Header("Retry-After", strconv.Itoa(limited.Seconds()))
+		c.ResponseJson(ctx, http.StatusTooManyRequests, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+}
+
+// completionResult 上游回复的解析结果，兼容chat和completion两种接口
+type completionResult struct {
+	Message      gogpt.ChatCompletionMessage
+	FinishReason string
+	Model        string
+	Usage        gogpt.Usage
+}
+
+// complete 调用上游生成回复，并记录本次调用的用量
+func complete(ctx *gin.Context, request gogpt.ChatCompletionRequest, chatID string, kind string) (*completionResult, error) {
+	cnf := config.LoadConfig()
+	if !isServerModel(cnf, kind, request.Model) {
+		model, err := resolveModel(request.Model)
+		if err != nil {
+			return nil, err
+		}
+		request.Model = model
+	}
+	if limit, ok := cnf.RateLimitModels[request.Model]; ok {
+		if err := ratelimit.Take("model:"+request.Model, ratelimit.PerMinute(limit.Requests, limit.Burst)); err != nil {
+			return nil, err
+		}
+	}
+
+	// 按实际发送给上游的消息和最大回复长度预估本次用量，用于预算检查和预扣余额
+	request.Messages = withSystemPrompt(ctx, request.Messages)
+	promptTokens := countPromptTokens(request)
+	maxTokens := request.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = cnf.MaxTokens
+	}
+	modelOption := cnf.FindModel(request.Model)
+	estimateCost := modelOption.Cost(promptTokens, maxTokens)
+	if err := checkQuota(ctx, promptTokens+maxTokens, estimateCost); err != nil {
+		return nil, err
+	}
+	hold, err := holdCredit(ctx, chatID, request.Model, estimateCost)
+	if err != nil {
+		return nil, err
+	}
+
+	// 上游并发已满时排队等待
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		releaseCredit(hold)
+		return nil, err
+	}
+	defer release()
+
+	// 上游始终使用非流式接口，以便获取用量信息
+	request.Stream = false
+	start := time.Now()
+	resp, err := CreateChatCompletion(ctx, request)
+	if err == nil {
+		var result *completionResult
+		result, err = parseCompletionResponse(resp)
+		if err == nil {
+			result.Model = request.Model
+			recordUsage(ctx, chatID, kind, result, time.Since(start))
+			settleCredit(hold, modelOption.Cost(result.Usage.PromptTokens, result.Usage.CompletionTokens), result.Usage.TotalTokens)
+			return result, nil
+		}
+	}
+	releaseCredit(hold)
+	return nil, err
+}
+
+// countPromptTokens 计算发送给上游的提示词token数，旧模型按拼接后的提示词计算
+func countPromptTokens(request gogpt.ChatCompletionRequest) int {
+	if isCompletionModel(request.Model) {
+		return tokenizer.Count(request.Model, completionPrompt(request.Messages))
+	}
+	return tokenizer.CountMessages(request.Model, request.Messages)
+}
+
+// resolveModel 校验请求指定的模型，未指定时使用默认模型，只允许使用model_options中配置的模型
+func resolveModel(model string) (string, error) {
+	cnf := config.LoadConfig()
+	if model == "" || model == cnf.Model {
+		return cnf.Model, nil
+	}
+	for _, item := range cnf.ModelOptions {
+		if item.Value == model {
+			return model, nil
+		}
+	}
+	return "", fmt.Errorf("不支持的模型：%s", model)
+}
+
+// isServerModel 是否是服务端为摘要、主题配置的模型，这些模型不由用户选择，不需要在model_options中
+func isServerModel(cnf *config.Configuration, kind string, model string) bool {
+	if model == "" {
+		return false
+	}
+	switch kind {
+	case usage.KindSummary:
+		return model == cnf.SummaryModel
+	case usage.KindSubject:
+		return model == cnf.SubjectModel
+	default:
+		return false
+	}
+}
+
+// parseCompletionResponse 解析CreateChatCompletion的返回值
+func parseCompletionResponse(resp any) (*completionResult, error) {
+	// 判断resp是不是gogpt.ChatCompletionResponse类型
+	if reflect.TypeOf(resp) == reflect.TypeOf(gogpt.ChatCompletionResponse{}) {
+		chatResp := resp.(gogpt.ChatCompletionResponse)
+		if len(chatResp.Choices) == 0 {
+			return nil, fmt.Errorf("接口未返回任何回复")
+		}
+		return &completionResult{
+			Message:      chatResp.Choices[0].Message,
+			FinishReason: string(chatResp.Choices[0].FinishReason),
+			Usage:        chatResp.Usage,
+		}, nil
+	}
+
+	textResp := resp.(gogpt.CompletionResponse)
+	if len(textResp.Choices) == 0 {
+		return nil, fmt.Errorf("接口未返回任何回复")
+	}
+	return &completionResult{
+		Message:      gogpt.ChatCompletionMessage{Role: "assistant", Content: textResp.Choices[0].Text},
+		FinishReason: textResp.Choices[0].FinishReason,
+		Usage:        textResp.Usage,
+	}, nil
+}
+
+// recordUsage 记录调用用量，记录失败不影响回复
+func recordUsage(ctx *gin.Context, chatID string, kind string, result *completionResult, latency time.Duration) {
+	item := &usage.Usage{
+		ChatID:           chatID,
+		Kind:             kind,
+		Model:            result.Model,
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		TotalTokens:      result.Usage.TotalTokens,
+		Latency:          latency.Milliseconds(),
+		Cost:             config.LoadConfig().FindModel(result.Model).Cost(result.Usage.PromptTokens, result.Usage.CompletionTokens),
+	}
+	if userInfo := GetLoginUser(ctx); userInfo != nil {
+		item.UserID = userInfo.ID
+	}
+	if current := currentApp(ctx); current != nil {
+		item.AppID = current.ID
+	}
+	if err := usage.Create(item); err != nil {
+		logger.Warning("record usage error:", err)
+	}
+}
+
+// acquireUpstream 获取上游并发名额，管理员优先，其余用户轮转排队
+// 流式请求从这里开始推送，排队期间会收到queue事件，告知当前排队位置
+func acquireUpstream(ctx *gin.Context) (func(), error) {
+	beginStream(ctx)
+	cnf := config.LoadConfig()
+	pool := queue.Get(upstreamKey(cnf), cnf.MaxConcurrency)
+
+	var userID uint64
+	priority := false
+	if userInfo := GetLoginUser(ctx); userInfo != nil {
+		userID = userInfo.ID
+		priority = userInfo.IsAdmin
+	}
+
+	var onPosition func(int)
+	if isStream(ctx) {
+		onPosition = func(position int) {
+			pushEvent(ctx, "queue", gin.H{"Position": position})
+		}
+	}
+
+	// queue_timeout为0表示一直等待，直到请求被取消
+	waitCtx := ctx.Request.Context()
+	if cnf.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(waitCtx, time.Duration(cnf.QueueTimeout)*time.Second)
+		defer cancel()
+	}
+	return pool.Acquire(waitCtx, userID, priority, onPosition)
+}
+
+// detachContext 复制Context供请求结束后的后台任务使用
+// 请求结束后gin会复用Context且请求的context会被取消，副本改用独立的context，在timeout后取消
+func detachContext(ctx *gin.Context, timeout time.Duration) (*gin.Context, context.CancelFunc) {
+	background := ctx.Copy()
+	// 后台任务不能再向已结束的响应推送事件
+	background.Set(streamRequestKey, false)
+	background.Set(streamKey, false)
+	detached, cancel := context.WithTimeout(context.Background(), timeout)
+	background.Request = ctx.Request.Clone(detached)
+	return background, cancel
+}
+
+// upstreamKey 上游地址及密钥的标识，不同上游使用不同的并发池
+func upstreamKey(cnf *config.Configuration) string {
+	hash := sha256.Sum256([]byte(cnf.ApiKey))
+	return cnf.ApiURL + "#" + hex.EncodeToString(hash[:4])
+}
+
+// requestStream 标记请求希望以SSE方式响应
+// 参数校验、限流、预算等检查通过后调用上游前才开始推送，之前的错误仍以对应的状态码返回，如429及Retry-After
+func requestStream(ctx *gin.Context) {
+	ctx.Set(streamRequestKey, true)
+}
+
+// beginStream 请求希望以SSE方式响应时开始推送，之后的结果均以事件推送，可以重复调用
+func beginStream(ctx *gin.Context) {
+	if !ctx.GetBool(streamRequestKey) || isStream(ctx) {
+		return
+	}
+	ctx.Set(streamKey, true)
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+}
+
+// isStream 当前请求是否已开始以SSE方式响应
+func isStream(ctx *gin.Context) bool {
+	return ctx.GetBool(streamKey)
+}
+
+// pushEvent 推送SSE事件
+func pushEvent(ctx *gin.Context, event string, data interface{}) {
+	ctx.SSEvent(event, data)
+	ctx.Writer.Flush()
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestIsServerModel(t *testing.T) {
+	cnf := &config.Configuration{SummaryModel: "cheap-summary", SubjectModel: "cheap-subject"}
+	tests := []struct {
+		kind  string
+		model string
+		want  bool
+	}{
+		{usage.KindSummary, "cheap-summary", true},
+		{usage.KindSubject, "cheap-subject", true},
+		{usage.KindSummary, "cheap-subject", false},
+		{usage.KindChat, "cheap-summary", false},
+		{usage.KindSummary, "", false},
+		{usage.KindSubject, "gpt-4", false},
+	}
+	for _, tt := range tests {
+		if got := isServerModel(cnf, tt.kind, tt.model); got != tt.want {
+			t.Errorf("isServerModel(%q, %q) = %v, want %v", tt.kind, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestCountPromptTokens(t *testing.T) {
+	messages := []gogpt.ChatCompletionMessage{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "hello world"},
+	}
+	tests := []struct {
+		model string
+		want  int
+	}{
+		// 聊天接口按消息计算，包含每条消息的格式开销
+		{"gpt-4", tokenizer.CountMessages("gpt-4", messages)},
+		// completion接口按拼接后的提示词计算
+		{gogpt.GPT3TextDavinci003, tokenizer.Count(gogpt.GPT3TextDavinci003, "You are a helpful assistant./nhello world")},
+	}
+	for _, tt := range tests {
+		if got := countPromptTokens(gogpt.ChatCompletionRequest{Model: tt.model, Messages: messages}); got != tt.want {
+			t.Errorf("countPromptTokens(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestWithSystemPrompt(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Set(systemPromptKey, "be brief")
+	messages := withSystemPrompt(ctx, []gogpt.ChatCompletionMessage{{Role: "user", Content: "hi"}})
+	if len(messages) != 2 || messages[0].Role != "system" || messages[0].Content != "be brief" {
+		t.Errorf("withSystemPrompt() = %+v", messages)
+	}
+	// 已有系统消息时不重复添加，预估用量和实际发送的消息一致
+	if again := withSystemPrompt(ctx, messages); len(again) != 2 {
+		t.Errorf("withSystemPrompt() twice = %+v", again)
+	}
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// continuePrompt 回复被截断后请求继续输出的提示
+const continuePrompt = "请从上次中断的地方继续输出，不要重复已经输出的内容"
+
+// isTruncated 回复是否因长度限制被截断
+func isTruncated(result *completionResult) bool {
+	return result.FinishReason == string(gogpt.FinishReasonLength)
+}
+
+// continueReserve 续写times次时需要在上下文窗口中额外预留的token数，written为已输出回复的token数
+// 最后一次续写发送已输出的回复、之前续写追加的内容以及续写提示，fitContext已为本次回复预留了max_tokens
+func continueReserve(request gogpt.ChatCompletionRequest, written int, times int) int {
+	if times <= 0 {
+		return 0
+	}
+	return tokenizer.CountMessage(request.Model, gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleAssistant}) + written +
+		tokenizer.CountMessage(request.Model, gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleUser, Content: continuePrompt}) +
+		(times-1)*replyMaxTokens(request)
+}
+
+// continueReply 回复被截断时以已输出的内容作为上下文继续请求，最多times次，续写内容合并到result中
+// 某次续写失败时返回已合并的结果及错误
+func continueReply(ctx *gin.Context, request gogpt.ChatCompletionRequest, chatID string, result *completionResult, times int) (*completionResult, error) {
+	history := request.Messages
+	for i := 0; i < times && isTruncated(result); i++ {
+		messages := make([]gogpt.ChatCompletionMessage, 0, len(history)+2)
+		messages = append(messages, history...)
+		messages = append(messages,
+			gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleAssistant, Content: result.Message.Content},
+			gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleUser, Content: continuePrompt},
+		)
+		request.Messages = messages
+
+		next, err := complete(ctx, request, chatID, usage.KindChat)
+		if err != nil {
+			return result, err
+		}
+		result = &completionResult{
+			Message:      gogpt.ChatCompletionMessage{Role: result.Message.Role, Content: result.Message.Content + next.Message.Content},
+			FinishReason: next.FinishReason,
+			Model:        next.Model,
+			Usage: gogpt.Usage{
+				PromptTokens:     result.Usage.PromptTokens + next.Usage.PromptTokens,
+				CompletionTokens: result.Usage.CompletionTokens + next.Usage.CompletionTokens,
+				TotalTokens:      result.Usage.TotalTokens + next.Usage.TotalTokens,
+			},
+		}
+	}
+	return result, nil
+}
+
+// Continue 继续输出被截断的回复，续写内容追加到同一条回复中
+// 除本次续写外，仍被截断时按auto_continue配置自动继续
+func (c *ChatController) Continue(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if request.Stream {
+		requestStream(ctx)
+	}
+	userInfo, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	request.UserID = userInfo.ID
+
+	messageId := request.MessageID
+	if messageId == 0 {
+		messageId = chatRecord.LeafID
+	}
+	message, err := chat.SelectMessage(chatRecord, messageId)
+	if err != nil || message.Role != gogpt.ChatMessageRoleAssistant || !message.Incomplete {
+		c.ResponseJson(ctx, customErrorCode, "该回复没有被截断，无需继续", nil)
+		return
+	}
+	history, err := chat.SelectPathTo(chatRecord, message.ParentID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	// 续写沿用原回复的模型，其余参数按当前设置
+	applyParams(&request.ChatCompletionRequest, resolveParams(ctx, paramsFromRequest(&request), chatRecord))
+	if message.Model != "" {
+		request.Model = message.Model
+	}
+	// 与发送新消息一样裁剪历史并以摘要代替被丢弃的对话，为已输出的回复和之后的续写预留空间
+	times := 1 + config.LoadConfig().AutoContinue
+	written := tokenizer.Count(request.Model, message.Content)
+	request.Messages, _, _ = fitMessages(ctx, request.ChatCompletionRequest, chatRecord, history, nil,
+		continueReserve(request.ChatCompletionRequest, written, times))
+	result := &completionResult{
+		Message:      gogpt.ChatCompletionMessage{Role: message.Role, Content: message.Content},
+		FinishReason: string(gogpt.FinishReasonLength),
+		Model:        message.Model,
+		Usage:        gogpt.Usage{PromptTokens: message.PromptTokens, CompletionTokens: message.Tokens},
+	}
+	result, err = continueReply(ctx, request.ChatCompletionRequest, request.ChatID, result, times)
+	if err != nil && result.Message.Content == message.Content {
+		c.responseError(ctx, err)
+		return
+	}
+
+	message.Content = result.Message.Content
+	message.Tokens = result.Usage.CompletionTokens
+	message.PromptTokens = result.Usage.PromptTokens
+	message.Incomplete = isTruncated(result)
+	err = chat.UpdateContinued(chatRecord, message)
+	if errors.Is(err, chat.ErrConflict) {
+		c.ResponseJson(ctx, http.StatusConflict, err.Error(), gin.H{"Reply": message.Content})
+		return
+	}
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Reply":      message.Content,
+		"MessageID":  message.ID,
+		"Incomplete": message.Incomplete,
+		"Warnings":   quotaWarnings(ctx),
+	})
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestContinueReserve(t *testing.T) {
+	request := gogpt.ChatCompletionRequest{Model: "gpt-4", MaxTokens: 500}
+	// 续写时除已输出的回复外，固定发送一条空的助手消息格式开销和续写提示
+	base := continueReserve(request, 0, 1)
+	if base <= 0 {
+		t.Fatalf("continueReserve(0, 1) = %d, want positive", base)
+	}
+	tests := []struct {
+		written int
+		times   int
+		want    int
+	}{
+		{100, 0, 0},
+		{100, -1, 0},
+		{100, 1, base + 100},
+		{100, 3, base + 100 + 2*500},
+		{0, 2, base + 500},
+	}
+	for _, tt := range tests {
+		if got := continueReserve(request, tt.written, tt.times); got != tt.want {
+			t.Errorf("continueReserve(%d, %d) = %d, want %d", tt.written, tt.times, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/credit"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/gin-gonic/gin"
+)
+
+const maxGenerateCodes = 1000
+
+// CreditController 余额控制器
+type CreditController struct {
+	BaseController
+}
+
+func NewCreditController() *CreditController {
+	return &CreditController{}
+}
+
+// creditRequest 余额请求
+type creditRequest struct {
+	Code       string  `json:"code"`
+	Count      int     `json:"count"`  // 生成兑换码数量
+	Amount     float64 `json:"amount"` // 兑换码面额或调整金额
+	Days       int     `json:"days"`   // 兑换码有效天数，0表示永不过期
+	OnlyUnused bool    `json:"onlyunused"`
+	UserName   string  `json:"username"`
+	Remark     string  `json:"remark"`
+	UserID     uint64  `json:"userid"`
+	Start      string  `json:"start"` // 导出开始日期，2006-01-02
+	End        string  `json:"end"`   // 导出结束日期，2006-01-02，包含当天
+	Limit      int     `json:"limit"`
+}
+
+// Balance 当前登录用户的余额及最近流水
+func (c *CreditController) Balance(ctx *gin.Context) {
+	var req creditRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	userInfo := GetLoginUser(ctx)
+	account, err := credit.GetAccount(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 50
+	}
+	entries, err := credit.ListLedger(userInfo.ID, nil, nil, req.Limit)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Enabled": config.LoadConfig().CreditEnabled,
+		"Balance": account.Balance,
+		"Ledger":  entries,
+	})
+}
+
+// Redeem 使用兑换码充值
+func (c *CreditController) Redeem(ctx *gin.Context) {
+	var req creditRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Code == "" {
+		c.ResponseJson(ctx, customErrorCode, "请输入兑换码", nil)
+		return
+	}
+
+	entry, err := credit.Redeem(GetLoginUser(ctx).ID, req.Code)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Amount":  entry.Amount,
+		"Balance": entry.Balance,
+	})
+}
+
+// GenerateCodes 批量生成兑换码
+func (c *CreditController) GenerateCodes(ctx *gin.Context) {
+	var req creditRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Count <= 0 || req.Count > maxGenerateCodes || req.Amount <= 0 {
+		c.ResponseJson(ctx, customErrorCode, fmt.Sprintf("生成数量须在1到%d之间，面额必须大于0", maxGenerateCodes), nil)
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.Days > 0 {
+		t := time.Now().AddDate(0, 0, req.Days)
+		expiresAt = &t
+	}
+	codes, err := credit.GenerateCodes(req.Count, req.Amount, GetLoginUser(ctx).ID, expiresAt)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Codes": codes,
+	})
+}
+
+// Codes 兑换码列表
+func (c *CreditController) Codes(ctx *gin.Context) {
+	var req creditRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	codes, err := credit.ListCodes(req.OnlyUnused)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Codes": codes,
+	})
+}
+
+// Adjust 管理员调整用户余额
+func (c *CreditController) Adjust(ctx *gin.Context) {
+	var req creditRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Amount == 0 {
+		c.ResponseJson(ctx, customErrorCode, "调整金额不能为0", nil)
+		return
+	}
+	userInfo, err := user.GetByName(req.UserName)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, "用户不存在", nil)
+		return
+	}
+
+	entry, err := credit.Adjust(userInfo.ID, req.Amount, req.Remark)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Balance": entry.Balance,
+	})
+}
+
+// Export 导出流水为CSV文件
+func (c *CreditController) Export(ctx *gin.Context) {
+	var req creditRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	var start, end *time.Time
+	if req.Start != "" {
+		t, err := time.ParseInLocation("2006-01-02", req.Start, time.Local)
+		if err != nil {
+			c.ResponseJson(ctx, customErrorCode, "开始日期格式错误", nil)
+			return
+		}
+		start = &t
+	}
+	if req.End != "" {
+		t, err := time.ParseInLocation("2006-01-02", req.End, time.Local)
+		if err != nil {
+			c.ResponseJson(ctx, customErrorCode, "结束日期格式错误", nil)
+			return
+		}
+		t = t.AddDate(0, 0, 1)
+		end = &t
+	}
+
+	entries, err := credit.ListLedger(req.UserID, start, end, 0)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ledger-%s.csv", time.Now().Format("20060102150405")))
+	// 写入BOM，避免Excel打开中文乱码
+	ctx.Writer.WriteString("\xEF\xBB\xBF")
+	writer := csv.NewWriter(ctx.Writer)
+	writer.Write([]string{"ID", "时间", "用户ID", "类型", "状态", "金额", "余额", "会话ID", "模型", "Token数", "备注"})
+	for _, entry := range entries {
+		writer.Write([]string{
+			strconv.FormatUint(entry.ID, 10),
+			entry.CreatedAt.Format("2006-01-02 15:04:05"),
+			strconv.FormatUint(entry.UserID, 10),
+			entry.Kind,
+			entry.Status,
+			strconv.FormatFloat(entry.Amount, 'f', -1, 64),
+			strconv.FormatFloat(entry.Balance, 'f', -1, 64),
+			entry.ChatID,
+			entry.Model,
+			strconv.Itoa(entry.Tokens),
+			entry.Remark,
+		})
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		logger.Warning("export ledger error:", err)
+	}
+}
+
+// holdCredit 开启预付费时，按预估费用预扣余额
+func holdCredit(ctx *gin.Context, chatID string, modelName string, cost float64) (*credit.Ledger, error) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		return nil, nil
+	}
+	return holdUserCredit(userInfo.ID, chatID, modelName, cost)
+}
+
+// holdUserCredit 为指定用户预扣余额，未开启余额时返回nil
+func holdUserCredit(userId uint64, chatID string, modelName string, cost float64) (*credit.Ledger, error) {
+	if !config.LoadConfig().CreditEnabled {
+		return nil, nil
+	}
+	return credit.Hold(userId, cost, chatID, modelName)
+}
+
+// settleCredit 按实际费用结算预扣的余额
+func settleCredit(hold *credit.Ledger, cost float64, tokens int) {
+	if hold == nil {
+		return
+	}
+	if err := credit.Settle(hold, cost, tokens); err != nil {
+		logger.Warning("settle credit error:", err)
+	}
+}
+
+// releaseCredit 调用失败时退回预扣的余额
+func releaseCredit(hold *credit.Ledger) {
+	if hold == nil {
+		return
+	}
+	if err := credit.Release(hold); err != nil {
+		logger.Warning("release credit error:", err)
+	}
+}
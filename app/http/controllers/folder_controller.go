@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/gin-gonic/gin"
+)
+
+// FolderController 会话文件夹控制器
+type FolderController struct {
+	BaseController
+}
+
+func NewFolderController() *FolderController {
+	return &FolderController{}
+}
+
+// folderRequest 文件夹请求
+type folderRequest struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+// List 获取自己的全部文件夹
+func (c *FolderController) List(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	folders, err := chat.SelectFolders(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Folders": folders,
+	})
+}
+
+// Save 新建或重命名文件夹
+func (c *FolderController) Save(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req folderRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		c.ResponseJson(ctx, customErrorCode, "文件夹名称不能为空且不能超过64个字", nil)
+		return
+	}
+
+	folder, err := chat.SaveFolder(userInfo.ID, req.ID, name)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Folder": folder,
+	})
+}
+
+// Delete 删除文件夹，其中的会话移到未分类
+func (c *FolderController) Delete(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req folderRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if err = chat.DeleteFolder(userInfo.ID, req.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/869413421/chatgpt-web/pkg/model/group"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/gin-gonic/gin"
+)
+
+// GroupController 用户组控制器
+type GroupController struct {
+	BaseController
+}
+
+func NewGroupController() *GroupController {
+	return &GroupController{}
+}
+
+// groupRequest 用户组请求
+type groupRequest struct {
+	ID          uint64 `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	UserName    string `json:"username"`
+	GroupID     uint64 `json:"groupid"`
+	paramsRequest
+}
+
+// List 用户组列表
+func (c *GroupController) List(ctx *gin.Context) {
+	groups, err := group.List()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Groups": groups,
+	})
+}
+
+// Save 新建或修改用户组
+func (c *GroupController) Save(ctx *gin.Context) {
+	var req groupRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Name == "" {
+		c.ResponseJson(ctx, customErrorCode, "用户组名称不能为空", nil)
+		return
+	}
+
+	params, err := req.toParams()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	item, err := group.Save(req.ID, req.Name, req.Description, params)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Group": item,
+	})
+}
+
+// Delete 删除用户组
+func (c *GroupController) Delete(ctx *gin.Context) {
+	var req groupRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if err = group.Delete(req.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// SetUser 设置用户所属用户组，groupid为0表示移出用户组
+func (c *GroupController) SetUser(ctx *gin.Context) {
+	var req groupRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.GroupID != 0 {
+		if _, err = group.Get(req.GroupID); err != nil {
+			c.ResponseJson(ctx, customErrorCode, "用户组不存在", nil)
+			return
+		}
+	}
+	if _, err = user.SetGroup(req.UserName, req.GroupID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
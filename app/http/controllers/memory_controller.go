@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/memory"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// systemPromptKey 缓存本次请求的系统提示词
+const systemPromptKey = "systemPrompt"
+
+// memoryExtractPrompt 从会话中提取长期记忆的提示词，依次填入已有记忆和对话内容
+const memoryExtractPrompt = "已知的用户信息：\n%s\n\n对话内容：\n%s\n请从对话中找出关于用户的、长期稳定且以后对话仍然有用的信息，例如使用的技术栈、命名习惯、个人偏好等，不要包含已知的信息和一次性的问题。每行输出一条，不要编号，没有则输出“无”。"
+
+// MemoryController 用户长期记忆控制器
+type MemoryController struct {
+	BaseController
+}
+
+func NewMemoryController() *MemoryController {
+	return &MemoryController{}
+}
+
+// memoryRequest 长期记忆请求
+type memoryRequest struct {
+	ID      uint64 `json:"id"`
+	Content string `json:"content"`
+	ChatID  string `json:"chatid"`
+}
+
+// List 获取当前用户的全部记忆
+func (c *MemoryController) List(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	memories, err := memory.List(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Memories": memories,
+	})
+}
+
+// Save 新增或修改记忆
+func (c *MemoryController) Save(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req memoryRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		c.ResponseJson(ctx, customErrorCode, "记忆内容不能为空", nil)
+		return
+	}
+
+	item, err := memory.Save(userInfo.ID, req.ID, content)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Memory": item,
+	})
+}
+
+// Delete 删除记忆
+func (c *MemoryController) Delete(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req memoryRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if err = memory.Delete(userInfo.ID, req.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// Extract 由用户主动发起，从指定会话中提取长期记忆
+func (c *MemoryController) Extract(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req memoryRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	chatRecord, err := chat.SelectRecordByChatId(req.ChatID)
+	if err != nil || chatRecord.UserID != userInfo.ID {
+		c.ResponseJson(ctx, customErrorCode, "不是当前登录用户的会话记录", nil)
+		return
+	}
+	path, err := chat.SelectPath(chatRecord)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	existing, err := memory.List(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	var known, dialog strings.Builder
+	for _, item := range existing {
+		known.WriteString(item.Content + "\n")
+	}
+	if known.Len() == 0 {
+		known.WriteString("无\n")
+	}
+	for _, item := range path {
+		dialog.WriteString(fmt.Sprintf("%s：%s\n", roleName(item.Role), item.Content))
+	}
+	request := gogpt.ChatCompletionRequest{
+		Messages: []gogpt.ChatCompletionMessage{
+			{Role: gogpt.ChatMessageRoleSystem, Content: "你负责整理关于用户的长期记忆。"},
+			{Role: gogpt.ChatMessageRoleUser, Content: fmt.Sprintf(memoryExtractPrompt, known.String(), dialog.String())},
+		},
+	}
+	result, err := complete(ctx, request, chatRecord.ChatID, usage.KindMemory)
+	if err != nil {
+		c.responseError(ctx, err)
+		return
+	}
+
+	// 每行一条记忆，跳过与已有记忆重复的内容
+	seen := make(map[string]bool, len(existing))
+	for _, item := range existing {
+		seen[item.Content] = true
+	}
+	var memories []*memory.Memory
+	for _, line := range strings.Split(result.Message.Content, "\n") {
+		content := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*•"))
+		if content == "" || content == "无" || seen[content] {
+			continue
+		}
+		seen[content] = true
+		memories = append(memories, &memory.Memory{UserID: userInfo.ID, Content: content, Source: memory.SourceExtract, ChatID: chatRecord.ChatID})
+	}
+	if err = memory.Create(memories); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Memories": memories,
+	})
+}
+
+// systemPrompt 本次请求使用的系统提示词，使用应用时为应用的提示词，否则依次使用角色、用户设置的默认提示词或bot_desc，之后附加当前用户的长期记忆
+// 记忆按创建顺序加入，超过memory_max_tokens后不再加入
+func systemPrompt(ctx *gin.Context) string {
+	if prompt, ok := ctx.Get(systemPromptKey); ok {
+		return prompt.(string)
+	}
+	// 应用的系统提示词固定，不附加用户的设置和记忆
+	if item := currentApp(ctx); item != nil {
+		return item.SystemPrompt
+	}
+
+	cnf := config.LoadConfig()
+	prompt := cnf.BotDesc
+	userInfo := GetLoginUser(ctx)
+	if item := currentPersona(ctx); item != nil {
+		prompt = item.SystemPrompt
+	} else if userInfo != nil {
+		profile, err := user.GetProfile(userInfo.ID)
+		if err != nil {
+			logger.Warning("load profile error:", err)
+		} else if profile.SystemPrompt != "" {
+			prompt = profile.SystemPrompt
+		}
+	}
+	if userInfo != nil && cnf.MemoryMaxTokens > 0 {
+		memories, err := memory.List(userInfo.ID)
+		if err != nil {
+			logger.Warning("load memories error:", err)
+		}
+		var builder strings.Builder
+		tokens := 0
+		for _, item := range memories {
+			tokens += tokenizer.Count(cnf.Model, item.Content) + 1
+			if tokens > cnf.MemoryMaxTokens {
+				break
+			}
+			builder.WriteString("- " + item.Content + "\n")
+		}
+		if builder.Len() > 0 {
+			prompt = strings.TrimSpace(prompt + "\n\n以下是关于用户的长期记忆：\n" + builder.String())
+		}
+	}
+	ctx.Set(systemPromptKey, prompt)
+	return prompt
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/gin-gonic/gin"
+)
+
+// 批量操作
+const (
+	bulkMove      = "move"
+	bulkTag       = "tag"
+	bulkUntag     = "untag"
+	bulkPin       = "pin"
+	bulkUnpin     = "unpin"
+	bulkArchive   = "archive"
+	bulkUnarchive = "unarchive"
+	bulkDelete    = "delete"
+)
+
+// bulkRequest 批量整理会话的请求
+type bulkRequest struct {
+	ChatIDs  []string `json:"chatids"`
+	Action   string   `json:"action"`
+	FolderID uint64   `json:"folderid"`
+	Tags     []string `json:"tags"`
+}
+
+// Bulk 批量移动、打标签、置顶、归档或删除会话，不属于当前用户的会话忽略
+func (c *ChatController) Bulk(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req bulkRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if len(req.ChatIDs) == 0 {
+		c.ResponseJson(ctx, customErrorCode, "请选择会话", nil)
+		return
+	}
+	records, err := chat.SelectRecordsByChatIds(userInfo.ID, req.ChatIDs)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	switch req.Action {
+	case bulkMove:
+		if req.FolderID != 0 {
+			if _, err = chat.SelectFolder(userInfo.ID, req.FolderID); err != nil {
+				c.ResponseJson(ctx, customErrorCode, "文件夹不存在", nil)
+				return
+			}
+		}
+		err = chat.UpdateRecords(records, map[string]interface{}{"folder_id": req.FolderID})
+	case bulkTag, bulkUntag:
+		var tags []string
+		if tags, err = normalizeTags(req.Tags); err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+			return
+		}
+		if req.Action == bulkTag {
+			err = chat.AddTags(records, tags)
+		} else {
+			err = chat.RemoveTags(records, tags)
+		}
+	case bulkPin, bulkUnpin:
+		err = chat.UpdateRecords(records, map[string]interface{}{"pinned": req.Action == bulkPin})
+	case bulkArchive, bulkUnarchive:
+		err = chat.UpdateRecords(records, map[string]interface{}{"archived": req.Action == bulkArchive})
+	case bulkDelete:
+		err = chat.DeleteRecords(records)
+	default:
+		c.ResponseJson(ctx, customErrorCode, "不支持的操作："+req.Action, nil)
+		return
+	}
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Affected": len(records),
+	})
+}
+
+// Tags 获取自己使用过的全部标签及对应的会话数
+func (c *ChatController) Tags(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	tags, err := chat.CountTags(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Tags": tags,
+	})
+}
+
+// normalizeTags 去掉标签的空白及重复项
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > 64 {
+			return nil, fmt.Errorf("标签不能超过64个字：%s", tag)
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("标签不能为空")
+	}
+	return result, nil
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/group"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// paramsRequest 设置模型及参数的请求，不传的字段表示不设置，使用下一级的设置
+type paramsRequest struct {
+	Model            string   `json:"model"`
+	Temperature      *float32 `json:"temperature"`
+	TopP             *float32 `json:"top_p"`
+	PresencePenalty  *float32 `json:"presence_penalty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty"`
+	MaxTokens        int      `json:"max_tokens"`
+}
+
+// toParams 校验并转换为模型参数
+func (r paramsRequest) toParams() (model.Params, error) {
+	params := model.Params{
+		Model:            r.Model,
+		Temperature:      r.Temperature,
+		TopP:             r.TopP,
+		PresencePenalty:  r.PresencePenalty,
+		FrequencyPenalty: r.FrequencyPenalty,
+		MaxTokens:        r.MaxTokens,
+	}
+	if params.Model != "" {
+		if _, err := resolveModel(params.Model); err != nil {
+			return params, err
+		}
+	}
+	if err := checkRange("temperature", params.Temperature, 0, 2); err != nil {
+		return params, err
+	}
+	if err := checkRange("top_p", params.TopP, 0, 1); err != nil {
+		return params, err
+	}
+	if err := checkRange("presence_penalty", params.PresencePenalty, -2, 2); err != nil {
+		return params, err
+	}
+	if err := checkRange("frequency_penalty", params.FrequencyPenalty, -2, 2); err != nil {
+		return params, err
+	}
+	if params.MaxTokens < 0 {
+		return params, fmt.Errorf("max_tokens不能小于0")
+	}
+	return params, nil
+}
+
+// checkRange 检查参数是否在取值范围内
+func checkRange(name string, value *float32, min float32, max float32) error {
+	if value != nil && (*value < min || *value > max) {
+		return fmt.Errorf("%s的取值范围为%g到%g", name, min, max)
+	}
+	return nil
+}
+
+// paramsFromRequest 请求中指定的模型及参数，模型和max_tokens为空视为未指定，采样参数传0也按指定处理
+func paramsFromRequest(request *ChatRequest) model.Params {
+	return model.Params{
+		Model:            request.Model,
+		Temperature:      request.sampling.Temperature,
+		TopP:             request.sampling.TopP,
+		PresencePenalty:  request.sampling.PresencePenalty,
+		FrequencyPenalty: request.sampling.FrequencyPenalty,
+		MaxTokens:        request.MaxTokens,
+	}
+}
+
+// globalParams 全局配置中的模型及参数
+func globalParams() model.Params {
+	cnf := config.LoadConfig()
+	temperature := float32(cnf.Temperature)
+	topP := cnf.TopP
+	presencePenalty := cnf.PresencePenalty
+	frequencyPenalty := cnf.FrequencyPenalty
+	return model.Params{
+		Model:            cnf.Model,
+		Temperature:      &temperature,
+		TopP:             &topP,
+		PresencePenalty:  &presencePenalty,
+		FrequencyPenalty: &frequencyPenalty,
+		MaxTokens:        cnf.MaxTokens,
+	}
+}
+
+// resolveParams 按请求、会话、角色、用户、用户组、全局配置的顺序确定本次使用的模型及参数
+func resolveParams(ctx *gin.Context, requested model.Params, chatRecord *chat.Record) model.Params {
+	params := requested
+	if chatRecord != nil {
+		params = params.Merge(chatRecord.Params)
+	}
+	if item := currentPersona(ctx); item != nil {
+		params = params.Merge(item.Params)
+	}
+	if userInfo := GetLoginUser(ctx); userInfo != nil {
+		// 登录信息中的用户组可能已变化，重新读取
+		current, err := user.GetByID(userInfo.ID)
+		if err != nil {
+			logger.Warning("load user error:", err)
+			current = userInfo
+		}
+		if profile, err := user.GetProfile(current.ID); err == nil {
+			params = params.Merge(profile.Params)
+		}
+		if current.GroupID != 0 {
+			if item, err := group.Get(current.GroupID); err == nil {
+				params = params.Merge(item.Params)
+			}
+		}
+	}
+	return params.Merge(globalParams())
+}
+
+// applyParams 将模型及参数设置到上游请求中
+func applyParams(request *gogpt.ChatCompletionRequest, params model.Params) {
+	request.Model = params.Model
+	request.MaxTokens = params.MaxTokens
+	if params.Temperature != nil {
+		request.Temperature = *params.Temperature
+	}
+	if params.TopP != nil {
+		request.TopP = *params.TopP
+	}
+	if params.PresencePenalty != nil {
+		request.PresencePenalty = *params.PresencePenalty
+	}
+	if params.FrequencyPenalty != nil {
+		request.FrequencyPenalty = *params.FrequencyPenalty
+	}
+}
+
+// chatParamsRequest 修改会话模型及参数的请求
+type chatParamsRequest struct {
+	ChatID string `json:"chatid"`
+	paramsRequest
+}
+
+// GetParams 获取会话的模型及参数，Effective为按优先级合并后实际使用的参数
+func (c *ChatController) GetParams(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Params":    chatRecord.Params,
+		"Effective": resolveParams(ctx, model.Params{}, chatRecord),
+	})
+}
+
+// SetParams 修改会话的模型及参数，之后的对话使用新的设置
+func (c *ChatController) SetParams(ctx *gin.Context) {
+	var request chatParamsRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	params, err := request.toParams()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if err = chat.UpdateParams(chatRecord, params); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Params": chatRecord.Params,
+	})
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParamsFromRequest(t *testing.T) {
+	zero, half := float32(0), float32(0.5)
+	tests := []struct {
+		body string
+		want paramsRequest
+	}{
+		// 未传的参数使用下一级的设置
+		{`{"content":"hi"}`, paramsRequest{}},
+		// 显式传0时按指定处理，不被会话、角色或全局配置覆盖
+		{`{"temperature":0,"top_p":0,"presence_penalty":0,"frequency_penalty":0}`,
+			paramsRequest{Temperature: &zero, TopP: &zero, PresencePenalty: &zero, FrequencyPenalty: &zero}},
+		{`{"model":"gpt-4","temperature":0.5,"max_tokens":100}`,
+			paramsRequest{Model: "gpt-4", Temperature: &half, MaxTokens: 100}},
+	}
+	for _, tt := range tests {
+		var request ChatRequest
+		if err := json.Unmarshal([]byte(tt.body), &request); err != nil {
+			t.Fatal(err)
+		}
+		got := paramsFromRequest(&request)
+		if got.Model != tt.want.Model || got.MaxTokens != tt.want.MaxTokens ||
+			!sameValue(got.Temperature, tt.want.Temperature) || !sameValue(got.TopP, tt.want.TopP) ||
+			!sameValue(got.PresencePenalty, tt.want.PresencePenalty) ||
+			!sameValue(got.FrequencyPenalty, tt.want.FrequencyPenalty) {
+			t.Errorf("paramsFromRequest(%s) = %+v", tt.body, got)
+		}
+	}
+	// 其余字段照常解析
+	var request ChatRequest
+	if err := json.Unmarshal([]byte(`{"chatid":"abc","content":"hi","temperature":0.5}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.ChatID != "abc" || request.Content != "hi" || request.Temperature != 0.5 {
+		t.Errorf("request = %+v", request)
+	}
+}
+
+// sameValue 比较两个可选参数是否相同
+func sameValue(a, b *float32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
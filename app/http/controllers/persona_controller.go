@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/persona"
+	"github.com/gin-gonic/gin"
+)
+
+// personaKey 本次请求使用的角色
+const personaKey = "persona"
+
+// errPersonaNotFound 角色不存在或不属于当前用户
+var errPersonaNotFound = errors.New("角色不存在")
+
+// PersonaController 角色控制器
+type PersonaController struct {
+	BaseController
+}
+
+func NewPersonaController() *PersonaController {
+	return &PersonaController{}
+}
+
+// personaRequest 角色请求，Global仅在管理员新建角色时有效
+type personaRequest struct {
+	ID           uint64 `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	SystemPrompt string `json:"system_prompt"`
+	Global       bool   `json:"global"`
+	paramsRequest
+}
+
+// List 获取全局角色和自己的角色
+func (c *PersonaController) List(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	personas, err := persona.List(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Personas": personas,
+	})
+}
+
+// Save 新建或修改角色，全局角色只有管理员可以新建和修改
+func (c *PersonaController) Save(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req personaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.SystemPrompt = strings.TrimSpace(req.SystemPrompt)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > 64 {
+		c.ResponseJson(ctx, customErrorCode, "角色名称不能为空且不能超过64个字", nil)
+		return
+	}
+	if req.SystemPrompt == "" {
+		c.ResponseJson(ctx, customErrorCode, "系统提示词不能为空", nil)
+		return
+	}
+	params, err := req.toParams()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	item := &persona.Persona{UserID: userInfo.ID}
+	if req.Global {
+		item.UserID = 0
+	}
+	if req.ID != 0 {
+		item, err = persona.Get(req.ID)
+		if err != nil {
+			c.ResponseJson(ctx, customErrorCode, errPersonaNotFound.Error(), nil)
+			return
+		}
+	}
+	if !c.canManage(ctx, item) {
+		return
+	}
+	item.Name = req.Name
+	item.Description = req.Description
+	item.SystemPrompt = req.SystemPrompt
+	item.Params = params
+	if err = persona.Save(item); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Persona": item,
+	})
+}
+
+// Delete 删除角色
+func (c *PersonaController) Delete(ctx *gin.Context) {
+	var req personaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	item, err := persona.Get(req.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, errPersonaNotFound.Error(), nil)
+		return
+	}
+	if !c.canManage(ctx, item) {
+		return
+	}
+	if err = persona.Delete(item.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// canManage 全局角色只有管理员可以管理，个人角色只有本人可以管理，无权限时直接返回错误响应
+func (c *PersonaController) canManage(ctx *gin.Context, item *persona.Persona) bool {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return false
+	}
+	if item.IsGlobal() && !userInfo.IsAdmin {
+		c.ResponseJson(ctx, http.StatusForbidden, "您不是管理员，无权进行此操作", nil)
+		return false
+	}
+	if !item.IsGlobal() && item.UserID != userInfo.ID {
+		c.ResponseJson(ctx, customErrorCode, errPersonaNotFound.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// usePersona 本次请求使用指定的角色，需要在第一次调用systemPrompt之前设置
+func usePersona(ctx *gin.Context, personaId uint64) error {
+	if personaId == 0 {
+		return nil
+	}
+	item, err := persona.Get(personaId)
+	if err != nil {
+		return errPersonaNotFound
+	}
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil || !item.VisibleTo(userInfo.ID) {
+		return errPersonaNotFound
+	}
+	ctx.Set(personaKey, item)
+	return nil
+}
+
+// useChatPersona 使用会话创建时选择的角色，角色已被删除时按用户和全局的设置回复
+func useChatPersona(ctx *gin.Context, chatRecord *chat.Record) {
+	if err := usePersona(ctx, chatRecord.PersonaID); err != nil {
+		logger.Warning("load persona error:", err)
+	}
+}
+
+// currentPersona 本次请求使用的角色，没有时返回nil
+func currentPersona(ctx *gin.Context) *persona.Persona {
+	if item, ok := ctx.Get(personaKey); ok {
+		return item.(*persona.Persona)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model/quota"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaController 预算控制器
+type QuotaController struct {
+	BaseController
+}
+
+func NewQuotaController() *QuotaController {
+	return &QuotaController{}
+}
+
+// quotaRequest 预算请求
+type quotaRequest struct {
+	ID        uint64  `json:"id"`
+	Scope     string  `json:"scope"`   // user或group
+	ScopeID   uint64  `json:"scopeid"` // 用户ID或用户组ID
+	Period    string  `json:"period"`  // day或month
+	Metric    string  `json:"metric"`  // tokens或cost
+	Limit     float64 `json:"limit"`
+	SoftLimit float64 `json:"softlimit"` // 软限制比例，0~1
+	Extra     float64 `json:"extra"`     // 临时额度
+	Hours     int     `json:"hours"`     // 临时额度有效小时数
+	Reason    string  `json:"reason"`
+}
+
+// List 预算列表及当前用量
+func (c *QuotaController) List(ctx *gin.Context) {
+	var req quotaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	items, err := quota.ListStatus(req.Scope, req.ScopeID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Budgets": items,
+	})
+}
+
+// Save 新建或修改预算
+func (c *QuotaController) Save(ctx *gin.Context) {
+	var req quotaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Scope != quota.ScopeUser && req.Scope != quota.ScopeGroup {
+		c.ResponseJson(ctx, customErrorCode, "预算对象只能是user或group", nil)
+		return
+	}
+	if req.Period != usage.PeriodDay && req.Period != usage.PeriodMonth {
+		c.ResponseJson(ctx, customErrorCode, "预算周期只能是day或month", nil)
+		return
+	}
+	if req.Metric != quota.MetricTokens && req.Metric != quota.MetricCost {
+		c.ResponseJson(ctx, customErrorCode, "计量方式只能是tokens或cost", nil)
+		return
+	}
+	if req.Limit <= 0 || req.SoftLimit < 0 || req.SoftLimit > 1 {
+		c.ResponseJson(ctx, customErrorCode, "额度必须大于0，软限制比例必须在0到1之间", nil)
+		return
+	}
+
+	budget, err := quota.SaveBudget(req.Scope, req.ScopeID, req.Period, req.Metric, req.Limit, req.SoftLimit)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Budget": budget,
+	})
+}
+
+// Delete 删除预算
+func (c *QuotaController) Delete(ctx *gin.Context) {
+	var req quotaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if err = quota.DeleteBudget(req.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// Reset 立即重置预算
+func (c *QuotaController) Reset(ctx *gin.Context) {
+	var req quotaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	budget, err := quota.ResetBudget(req.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Budget": budget,
+	})
+}
+
+// Override 授予临时额度
+func (c *QuotaController) Override(ctx *gin.Context) {
+	var req quotaRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Extra <= 0 || req.Hours <= 0 {
+		c.ResponseJson(ctx, customErrorCode, "临时额度和有效小时数必须大于0", nil)
+		return
+	}
+
+	override, err := quota.GrantOverride(req.ID, req.Extra, time.Duration(req.Hours)*time.Hour, req.Reason, GetLoginUser(ctx).ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Override": override,
+	})
+}
+
+// checkQuota 调用上游前按预估用量检查用户及用户组预算
+func checkQuota(ctx *gin.Context, tokens int, cost float64) error {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		return nil
+	}
+	return checkUserQuota(userInfo.ID, tokens, cost)
+}
+
+// checkUserQuota 检查指定用户的预算，用于后台任务等没有登录信息的调用
+func checkUserQuota(userId uint64, tokens int, cost float64) error {
+	// token中的用户信息可能已过期，重新获取用户组
+	current, err := user.GetByID(userId)
+	if err != nil {
+		return err
+	}
+	return quota.Check(current.ID, current.GroupID, tokens, cost)
+}
+
+// quotaWarnings 用量超过软限制时的提示信息
+func quotaWarnings(ctx *gin.Context) []string {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		return nil
+	}
+	current, err := user.GetByID(userInfo.ID)
+	if err != nil {
+		return nil
+	}
+	warnings, err := quota.Warnings(current.ID, current.GroupID)
+	if err != nil {
+		return nil
+	}
+	return warnings
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/gin-gonic/gin"
+)
+
+// maxSearchResults 搜索最多返回的消息数
+const maxSearchResults = 100
+
+// searchRequest 搜索请求
+type searchRequest struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// Search 搜索自己的会话主题和消息内容
+func (c *ChatController) Search(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req searchRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	if req.Limit > maxSearchResults {
+		req.Limit = maxSearchResults
+	}
+
+	results, err := chat.Search(userInfo.ID, req.Query, req.Limit)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	items := make([]gin.H, 0, len(results))
+	for _, item := range results {
+		link := "/?chatid=" + url.QueryEscape(item.ChatID)
+		if item.MessageID != 0 {
+			link += fmt.Sprintf("&messageid=%d", item.MessageID)
+		}
+		items = append(items, gin.H{
+			"ChatID":    item.ChatID,
+			"Subject":   item.Subject,
+			"MessageID": item.MessageID,
+			"Role":      item.Role,
+			"Snippet":   item.Snippet,
+			"CreatedAt": item.CreatedAt,
+			"Link":      link,
+		})
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Results": items,
+	})
+}
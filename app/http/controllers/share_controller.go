@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/869413421/chatgpt-web/pkg/auth"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/password"
+)
+
+// errSharePassword 分享密码错误
+var errSharePassword = errors.New("访问密码错误")
+
+// ShareController 会话分享控制器
+type ShareController struct {
+	BaseController
+}
+
+func NewShareController() *ShareController {
+	return &ShareController{}
+}
+
+// shareRequest 分享请求
+type shareRequest struct {
+	ID        uint64 `json:"id"`
+	ChatID    string `json:"chatid"`
+	MessageID uint64 `json:"messageid"`
+	Token     string `json:"token"`
+	Password  string `json:"password"`
+	// Days 有效天数，0表示永不过期
+	Days int `json:"days"`
+}
+
+// Create 以会话当前分支创建分享，传入messageid时只分享到该消息为止
+func (c *ShareController) Create(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req shareRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if req.Days < 0 {
+		c.ResponseJson(ctx, customErrorCode, "有效天数不能小于0", nil)
+		return
+	}
+	if len(req.Password) > password.MaxLength {
+		c.ResponseJson(ctx, customErrorCode, fmt.Sprintf("访问密码不能超过%d个字节", password.MaxLength), nil)
+		return
+	}
+	chatRecord, err := chat.SelectRecordByChatId(req.ChatID)
+	if err != nil || chatRecord.UserID != userInfo.ID {
+		c.ResponseJson(ctx, customErrorCode, "不是当前登录用户的会话记录", nil)
+		return
+	}
+
+	var path []*chat.Message
+	if req.MessageID != 0 {
+		path, err = chat.SelectPathTo(chatRecord, req.MessageID)
+	} else {
+		path, err = chat.SelectPath(chatRecord)
+	}
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if len(path) == 0 {
+		c.ResponseJson(ctx, customErrorCode, "会话没有内容", nil)
+		return
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		if passwordHash, err = password.Generate(req.Password); err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+			return
+		}
+	}
+	var expiresAt *time.Time
+	if req.Days > 0 {
+		t := time.Now().AddDate(0, 0, req.Days)
+		expiresAt = &t
+	}
+	share, err := chat.CreateShare(chatRecord, path, passwordHash, expiresAt)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Share": shareView(share),
+	})
+}
+
+// List 获取自己的分享，传入chatid时只查询该会话的分享
+func (c *ShareController) List(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req shareRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	var recordId uint64
+	if req.ChatID != "" {
+		chatRecord, err := chat.SelectRecordByChatId(req.ChatID)
+		if err != nil || chatRecord.UserID != userInfo.ID {
+			c.ResponseJson(ctx, customErrorCode, "不是当前登录用户的会话记录", nil)
+			return
+		}
+		recordId = chatRecord.ID
+	}
+	shares, err := chat.SelectShares(userInfo.ID, recordId)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	items := make([]gin.H, 0, len(shares))
+	for _, share := range shares {
+		items = append(items, shareView(share))
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Shares": items,
+	})
+}
+
+// Revoke 撤销分享，撤销后链接无法再访问
+func (c *ShareController) Revoke(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req shareRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if err = chat.RevokeShare(userInfo.ID, req.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// Fork 把分享的对话复制为自己的新会话，chatid不填时自动生成
+func (c *ShareController) Fork(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req shareRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	share, err := openShare(req.Token, req.Password)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if req.ChatID == "" {
+		if req.ChatID, err = randomChatID(); err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+			return
+		}
+	}
+	chatRecord, err := chat.ForkShare(share, userInfo.ID, req.ChatID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"ChatID":  chatRecord.ChatID,
+		"Subject": chatRecord.Subject,
+	})
+}
+
+// View 无需登录的只读分享页面，设置了密码时先要求输入密码
+func (c *ShareController) View(ctx *gin.Context) {
+	// 链接即凭证，不缓存、不收录、不通过Referer泄露
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("X-Robots-Tag", "noindex, nofollow")
+	ctx.Header("Referrer-Policy", "no-referrer")
+
+	token := ctx.Param("token")
+	page := sharePage{Token: token}
+	share, err := chat.SelectAvailableShare(token)
+	if err != nil {
+		page.Error = err.Error()
+		renderSharePage(ctx, http.StatusNotFound, page)
+		return
+	}
+	page.Subject = share.Subject
+	if share.HasPassword() {
+		if ctx.Request.Method != http.MethodPost {
+			page.NeedPassword = true
+			renderSharePage(ctx, http.StatusOK, page)
+			return
+		}
+		page.Password = ctx.PostForm("password")
+		if err = checkSharePassword(share, page.Password); err != nil {
+			page.NeedPassword = true
+			page.Error = err.Error()
+			status := http.StatusForbidden
+			if errors.Is(err, chat.ErrShareLocked) {
+				status = http.StatusTooManyRequests
+			}
+			renderSharePage(ctx, status, page)
+			return
+		}
+	}
+
+	if err = chat.AddShareView(share); err != nil {
+		page.Error = err.Error()
+		renderSharePage(ctx, http.StatusInternalServerError, page)
+		return
+	}
+	page.Messages = share.Messages
+	page.CreatedAt = share.CreatedAt
+	page.LoggedIn = shareViewer(ctx)
+	renderSharePage(ctx, http.StatusOK, page)
+}
+
+// openShare 查询可以访问的分享并校验密码
+func openShare(token string, pass string) (*chat.Share, error) {
+	share, err := chat.SelectAvailableShare(token)
+	if err != nil {
+		return nil, err
+	}
+	if share.HasPassword() {
+		if err = checkSharePassword(share, pass); err != nil {
+			return nil, err
+		}
+	}
+	return share, nil
+}
+
+// checkSharePassword 校验分享密码，按分享计数连续输错的次数，超出后锁定一段时间
+func checkSharePassword(share *chat.Share, pass string) error {
+	if err := chat.BeginSharePassword(share, time.Now()); err != nil {
+		return err
+	}
+	ok := password.CheckHash(pass, share.Password)
+	if err := chat.EndSharePassword(share, ok, time.Now()); err != nil {
+		return err
+	}
+	if !ok {
+		return errSharePassword
+	}
+	return nil
+}
+
+// shareViewer 分享页面不经过Jwt中间件，从前端保存登录凭证的cookie判断访问者是否已登录
+func shareViewer(ctx *gin.Context) bool {
+	token, err := ctx.Cookie("mojolicious")
+	if err != nil || token == "" {
+		return false
+	}
+	claims, err := auth.Decode(token)
+	return err == nil && claims.User.ID != 0
+}
+
+// shareView 返回给分享者的分享信息，不包含密码和快照内容
+func shareView(share *chat.Share) gin.H {
+	return gin.H{
+		"ID":          share.ID,
+		"Token":       share.Token,
+		"URL":         siteURL() + "/s/" + share.Token,
+		"RecordID":    share.RecordID,
+		"Subject":     share.Subject,
+		"HasPassword": share.HasPassword(),
+		"ExpiresAt":   share.ExpiresAt,
+		"Revoked":     share.Revoked,
+		"Available":   share.Available(time.Now()),
+		"Views":       share.Views,
+		"CreatedAt":   share.CreatedAt,
+	}
+}
+
+// randomChatID 复制分享时生成新会话的ID
+func randomChatID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// sharePage 分享页面的数据
+type sharePage struct {
+	Token        string
+	Subject      string
+	Messages     []chat.SharedMessage
+	CreatedAt    time.Time
+	NeedPassword bool
+	// Password 访问者输入的密码，复制到自己的会话时需要再次提交
+	Password string
+	LoggedIn bool
+	Error    string
+}
+
+func renderSharePage(ctx *gin.Context, code int, page sharePage) {
+	ctx.Status(code)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	if err := shareTemplate.Execute(ctx.Writer, page); err != nil {
+		_ = ctx.Error(err)
+	}
+}
+
+// shareTemplate 分享页面模板，不依赖前端构建产物，开发模式下也可以访问
+var shareTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if .Subject}}{{.Subject}}{{else}}会话分享{{end}}</title>
+<style>
+body { margin: 0; background: #f7f7f8; color: #343541; font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; }
+main { max-width: 820px; margin: 0 auto; padding: 24px 16px 48px; }
+h1 { font-size: 22px; margin: 0 0 4px; }
+.meta { color: #8e8ea0; font-size: 13px; margin-bottom: 24px; }
+.message { background: #fff; border-radius: 8px; padding: 14px 16px; margin-bottom: 12px; box-shadow: 0 1px 2px rgba(0, 0, 0, .06); }
+.message.assistant { background: #f0f4ff; }
+.role { font-size: 12px; color: #8e8ea0; margin-bottom: 6px; }
+.content { white-space: pre-wrap; word-break: break-word; line-height: 1.6; }
+.error { color: #d93025; margin: 12px 0; }
+form { display: flex; gap: 8px; }
+input, button, a.button { font-size: 14px; padding: 8px 12px; border-radius: 6px; border: 1px solid #d9d9e3; }
+button, a.button { background: #10a37f; color: #fff; border-color: #10a37f; cursor: pointer; text-decoration: none; }
+.actions { margin-top: 24px; }
+</style>
+</head>
+<body>
+<main>
+{{if .NeedPassword}}
+<h1>{{.Subject}}</h1>
+<p class="meta">该分享需要访问密码</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post">
+<input type="password" name="password" placeholder="访问密码" autofocus required>
+<button type="submit">查看</button>
+</form>
+{{else if .Error}}
+<h1>会话分享</h1>
+<p class="error">{{.Error}}</p>
+{{else}}
+<h1>{{.Subject}}</h1>
+<p class="meta">分享于 {{.CreatedAt.Format "2006-01-02 15:04"}}，只读</p>
+{{range .Messages}}
+<div class="message {{.Role}}">
+<div class="role">{{if eq .Role "user"}}用户{{else if eq .Role "assistant"}}助手{{if .Model}} · {{.Model}}{{end}}{{else}}{{.Role}}{{end}}</div>
+<div class="content">{{.Content}}</div>
+</div>
+{{end}}
+<div class="actions">
+{{if .LoggedIn}}
+<button type="button" id="fork">复制到我的会话</button>
+<p class="error" id="fork-error"></p>
+{{else}}
+<a class="button" href="/">登录后可复制到我的会话</a>
+{{end}}
+</div>
+{{end}}
+</main>
+{{if .LoggedIn}}
+<script>
+document.getElementById("fork").addEventListener("click", function () {
+  var match = document.cookie.match(/(?:^|;\s*)mojolicious=([^;]*)/);
+  fetch("/share/fork", {
+    method: "POST",
+    headers: {"Content-Type": "application/json", "Authorization": "Bearer " + (match ? decodeURIComponent(match[1]) : "")},
+    body: JSON.stringify({token: {{.Token}}, password: {{.Password}}})
+  }).then(function (res) { return res.json(); }).then(function (res) {
+    if (res.code === 200) {
+      location.href = "/?chatid=" + encodeURIComponent(res.data.ChatID);
+    } else {
+      document.getElementById("fork-error").textContent = res.errorMsg;
+    }
+  });
+});
+</script>
+{{end}}
+</body>
+</html>
+`))
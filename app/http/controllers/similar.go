@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/credit"
+	"github.com/869413421/chatgpt-web/pkg/model/embedding"
+	"github.com/869413421/chatgpt-web/pkg/model/quota"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+)
+
+const (
+	// embeddingBatchSize 每次计算向量的消息数
+	embeddingBatchSize = 50
+	// embeddingInterval 后台计算向量的间隔
+	embeddingInterval = 30 * time.Second
+	// embeddingMaxRunes 计算向量时消息截取的最大字数，避免超出模型输入限制
+	embeddingMaxRunes = 4000
+	// maxSimilarResults 相似会话最多返回的数量
+	maxSimilarResults = 50
+)
+
+var embeddingOnce sync.Once
+
+// StartEmbeddingWorker 配置了embedding_model时，在后台定时为新消息计算向量
+func StartEmbeddingWorker() {
+	cnf := config.LoadConfig()
+	if cnf.EmbeddingModel == "" {
+		return
+	}
+	embeddingOnce.Do(func() {
+		embedding.SetupIndex(cnf.EmbeddingDimensions)
+		go func() {
+			ticker := time.NewTicker(embeddingInterval)
+			defer ticker.Stop()
+			for {
+				if err := embedPending(); err != nil {
+					logger.Warning("compute embeddings error:", err)
+				}
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// embedPending 为还没有向量或向量模型已更换的消息按用户分批计算向量，直到没有新消息
+// 超出预算或余额不足的用户本轮跳过，下一轮再试，不影响其他用户
+func embedPending() error {
+	if err := embedding.DeleteOrphans(); err != nil {
+		return err
+	}
+	modelName := config.LoadConfig().EmbeddingModel
+	var skipped []uint64
+	for {
+		items, err := embedding.SelectPending(modelName, skipped, embeddingBatchSize)
+		if err != nil {
+			return err
+		}
+		byUser := make(map[uint64][]*embedding.Pending)
+		for _, item := range items {
+			byUser[item.UserID] = append(byUser[item.UserID], item)
+		}
+		for userId, pending := range byUser {
+			rows, err := embedUserMessages(userId, modelName, pending)
+			if isLimitError(err) {
+				logger.Warning(fmt.Sprintf("skip embeddings of user %d: %v", userId, err))
+				skipped = append(skipped, userId)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err = embedding.Add(rows); err != nil {
+				return err
+			}
+		}
+		if len(items) < embeddingBatchSize {
+			return nil
+		}
+	}
+}
+
+// embedUserMessages 计算用户一批消息的向量，上游拒绝整批输入时逐条重试
+// 单独计算仍被拒绝的消息与空消息一样保存空向量，避免每轮重复请求阻塞后续消息
+func embedUserMessages(userId uint64, modelName string, pending []*embedding.Pending) ([]*embedding.Embedding, error) {
+	rows := make([]*embedding.Embedding, 0, len(pending))
+	inputs := make([]string, 0, len(pending))
+	embedded := make([]*embedding.Embedding, 0, len(pending))
+	for _, item := range pending {
+		row := &embedding.Embedding{MessageID: item.MessageID, RecordID: item.RecordID, UserID: userId, Model: modelName}
+		rows = append(rows, row)
+		if text := embeddingText(item.Content); text != "" {
+			inputs = append(inputs, text)
+			embedded = append(embedded, row)
+		}
+	}
+	vectors, err := createEmbeddings(context.Background(), userId, "", inputs)
+	if err == nil {
+		for i, row := range embedded {
+			row.Vector = embedding.Encode(vectors[i])
+		}
+		return rows, nil
+	}
+	if !isRejectedInput(err) {
+		return nil, err
+	}
+	for i, row := range embedded {
+		vectors, err = createEmbeddings(context.Background(), userId, "", inputs[i:i+1])
+		if isRejectedInput(err) {
+			logger.Warning(fmt.Sprintf("skip embedding of message %d: %v", row.MessageID, err))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		row.Vector = embedding.Encode(vectors[0])
+	}
+	return rows, nil
+}
+
+// isLimitError 是否是超出预算或余额不足，这类错误等额度恢复后可以重试
+func isLimitError(err error) bool {
+	var exceeded *quota.ExceededError
+	return errors.As(err, &exceeded) || errors.Is(err, credit.ErrInsufficient)
+}
+
+// isRejectedInput 上游是否因输入内容拒绝请求，重试也不会成功
+// 网络错误、限流、鉴权失败等与消息无关的错误返回false，等下一轮再试
+func isRejectedInput(err error) bool {
+	status := 0
+	var apiErr *gogpt.APIError
+	var reqErr *gogpt.RequestError
+	if errors.As(err, &apiErr) {
+		status = apiErr.HTTPStatusCode
+	} else if errors.As(err, &reqErr) {
+		status = reqErr.HTTPStatusCode
+	}
+	return status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge || status == http.StatusUnprocessableEntity
+}
+
+// embeddingText 计算向量的文本，换行替换为空格并截取开头部分
+func embeddingText(content string) string {
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	if len(runes) > embeddingMaxRunes {
+		runes = runes[:embeddingMaxRunes]
+	}
+	return string(runes)
+}
+
+// createEmbeddings 调用接口计算向量，返回的向量与inputs顺序一致
+// 与对话一样先检查用户预算并预扣余额，完成后记录用量并按实际费用结算
+func createEmbeddings(ctx context.Context, userId uint64, chatId string, inputs []string) ([][]float32, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+	cnf := config.LoadConfig()
+	modelOption := cnf.FindModel(cnf.EmbeddingModel)
+	tokens := 0
+	for _, input := range inputs {
+		tokens += tokenizer.Count(cnf.EmbeddingModel, input)
+	}
+	estimateCost := modelOption.Cost(tokens, 0)
+	if err := checkUserQuota(userId, tokens, estimateCost); err != nil {
+		return nil, err
+	}
+	hold, err := holdUserCredit(userId, chatId, cnf.EmbeddingModel, estimateCost)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	vectors, result, err := requestEmbeddings(ctx, cnf, inputs)
+	if err != nil {
+		releaseCredit(hold)
+		return nil, err
+	}
+	cost := modelOption.Cost(result.PromptTokens, 0)
+	err = usage.Create(&usage.Usage{
+		UserID:       userId,
+		ChatID:       chatId,
+		Kind:         usage.KindEmbedding,
+		Model:        cnf.EmbeddingModel,
+		PromptTokens: result.PromptTokens,
+		TotalTokens:  result.TotalTokens,
+		Latency:      time.Since(start).Milliseconds(),
+		Cost:         cost,
+	})
+	if err != nil {
+		logger.Warning("record usage error:", err)
+	}
+	settleCredit(hold, cost, result.TotalTokens)
+	return vectors, nil
+}
+
+// requestEmbeddings 请求上游计算向量，配置了embedding_dimensions时指定返回的维度
+func requestEmbeddings(ctx context.Context, cnf *config.Configuration, inputs []string) ([][]float32, gogpt.Usage, error) {
+	resp, err := newClient(cnf).CreateEmbeddings(ctx, gogpt.EmbeddingRequestStrings{
+		Input:      inputs,
+		Model:      gogpt.EmbeddingModel(cnf.EmbeddingModel),
+		Dimensions: cnf.EmbeddingDimensions,
+	})
+	if err != nil {
+		return nil, gogpt.Usage{}, err
+	}
+	if len(resp.Data) != len(inputs) {
+		return nil, gogpt.Usage{}, fmt.Errorf("embedding count mismatch: want %d, got %d", len(inputs), len(resp.Data))
+	}
+	vectors := make([][]float32, len(inputs))
+	for _, item := range resp.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, gogpt.Usage{}, fmt.Errorf("embedding index out of range: %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, resp.Usage, nil
+}
+
+// similarRequest 查找相似会话请求，ChatID和Query二选一
+type similarRequest struct {
+	ChatID string `json:"chatid"`
+	Query  string `json:"query"`
+	Limit  int    `json:"limit"`
+}
+
+// Similar 按语义查找与指定会话或文字相似的历史会话
+func (c *ChatController) Similar(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req similarRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	modelName := config.LoadConfig().EmbeddingModel
+	if modelName == "" {
+		c.ResponseJson(ctx, customErrorCode, "未开启语义搜索", nil)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 10
+	}
+	if req.Limit > maxSimilarResults {
+		req.Limit = maxSimilarResults
+	}
+
+	var vector []float32
+	var sourceId uint64
+	if req.ChatID != "" {
+		_, chatRecord, ok := c.ownChatRecord(ctx, req.ChatID)
+		if !ok {
+			return
+		}
+		sourceId = chatRecord.ID
+		vector, err = chatVector(ctx, chatRecord, modelName)
+	} else {
+		text := embeddingText(req.Query)
+		if text == "" {
+			c.ResponseJson(ctx, customErrorCode, "请输入会话ID或搜索内容", nil)
+			return
+		}
+		var vectors [][]float32
+		vectors, err = createEmbeddings(ctx, userInfo.ID, "", []string{text})
+		if err == nil {
+			vector = vectors[0]
+		}
+	}
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	// 同一会话可能命中多条消息，多取一些再按会话合并
+	matches, err := embedding.Search(userInfo.ID, modelName, vector, sourceId, req.Limit*10)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	best := make(map[uint64]*embedding.Match)
+	for _, match := range matches {
+		if current, ok := best[match.RecordID]; !ok || match.Score > current.Score {
+			best[match.RecordID] = match
+		}
+	}
+	ranked := make([]*embedding.Match, 0, len(best))
+	for _, match := range best {
+		ranked = append(ranked, match)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	if len(ranked) > req.Limit {
+		ranked = ranked[:req.Limit]
+	}
+
+	recordIds := make([]uint64, 0, len(ranked))
+	messageIds := make([]uint64, 0, len(ranked))
+	for _, match := range ranked {
+		recordIds = append(recordIds, match.RecordID)
+		messageIds = append(messageIds, match.MessageID)
+	}
+	records, err := chat.SelectRecordsByIds(userInfo.ID, recordIds)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	messages, err := chat.SelectMessagesByIds(messageIds)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	recordMap := make(map[uint64]*chat.Record, len(records))
+	for _, record := range records {
+		recordMap[record.ID] = record
+	}
+	// 与/chat/search一样返回HTML转义后的主题和摘录，前端可以按相同方式展示
+	snippets := make(map[uint64]string, len(messages))
+	for _, message := range messages {
+		snippets[message.ID] = html.EscapeString(chat.Excerpt(message.Content))
+	}
+
+	items := make([]gin.H, 0, len(ranked))
+	for _, match := range ranked {
+		record, ok := recordMap[match.RecordID]
+		if !ok {
+			continue
+		}
+		items = append(items, gin.H{
+			"ChatID":    record.ChatID,
+			"Subject":   html.EscapeString(record.Subject),
+			"Score":     match.Score,
+			"MessageID": match.MessageID,
+			"Snippet":   snippets[match.MessageID],
+			"Link":      fmt.Sprintf("/?chatid=%s&messageid=%d", url.QueryEscape(record.ChatID), match.MessageID),
+		})
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Results": items,
+	})
+}
+
+// chatVector 会话的向量，取已计算的消息向量的平均值，还没有计算时用当前分支的对话即时计算
+func chatVector(ctx context.Context, chatRecord *chat.Record, modelName string) ([]float32, error) {
+	items, err := embedding.SelectByRecord(chatRecord.ID)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, 0, len(items))
+	for _, item := range items {
+		if item.Model == modelName && len(item.Vector) > 0 {
+			vectors = append(vectors, embedding.Decode(item.Vector))
+		}
+	}
+	if len(vectors) > 0 {
+		return embedding.Mean(vectors), nil
+	}
+
+	path, err := chat.SelectPath(chatRecord)
+	if err != nil {
+		return nil, err
+	}
+	contents := []string{chatRecord.Subject}
+	for _, message := range path {
+		if message.Role == gogpt.ChatMessageRoleUser || message.Role == gogpt.ChatMessageRoleAssistant {
+			contents = append(contents, message.Content)
+		}
+	}
+	text := embeddingText(strings.Join(contents, " "))
+	if text == "" {
+		return nil, errors.New("会话没有内容")
+	}
+	result, err := createEmbeddings(ctx, chatRecord.UserID, chatRecord.ChatID, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return result[0], nil
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// subjectTimeout 后台生成主题的最长时间，包括排队等待
+const subjectTimeout = 2 * time.Minute
+
+// errSubjectChanged 生成主题期间会话已被重命名或删除
+var errSubjectChanged = errors.New("会话主题已被修改")
+
+// subjectMaxLength 主题的最大字数
+func subjectMaxLength() int {
+	if length := config.LoadConfig().SubjectMaxLength; length > 0 {
+		return length
+	}
+	return 15
+}
+
+// fallbackSubject 截取第一条消息作为临时主题，主题生成失败时继续使用
+func fallbackSubject(text string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if length := subjectMaxLength(); len(runes) > length {
+		return string(runes[:length])
+	}
+	return string(runes)
+}
+
+// generateSubject 根据第一条消息生成会话主题并保存
+func generateSubject(ctx *gin.Context, chatRecord *chat.Record, text string) (string, error) {
+	cnf := config.LoadConfig()
+	prompt := cnf.SubjectPrompt
+	if prompt == "" {
+		prompt = config.DefaultSubjectPrompt
+	}
+	language := cnf.SubjectLanguage
+	if language == "" {
+		language = "与原文相同的语言"
+	}
+	prompt = strings.NewReplacer(
+		"{{text}}", text,
+		"{{max_length}}", strconv.Itoa(subjectMaxLength()),
+		"{{language}}", language,
+	).Replace(prompt)
+
+	request := gogpt.ChatCompletionRequest{
+		Model:    cnf.SubjectModel,
+		Messages: []gogpt.ChatCompletionMessage{{Role: gogpt.ChatMessageRoleUser, Content: prompt}},
+	}
+	previous := chatRecord.Subject
+	result, err := complete(ctx, request, chatRecord.ChatID, usage.KindSubject)
+	if err != nil {
+		return "", err
+	}
+	// 去掉模型可能带上的引号和标点
+	subject := fallbackSubject(strings.Trim(strings.TrimSpace(result.Message.Content), "\"'“”‘’《》「」。."))
+	if subject == "" {
+		return chatRecord.Subject, nil
+	}
+	updated, err := chat.ReplaceSubject(chatRecord, previous, subject)
+	if err != nil {
+		return "", err
+	}
+	if !updated {
+		return "", errSubjectChanged
+	}
+	return subject, nil
+}
+
+// generateSubjectAfterReply 回复返回后生成新会话的主题
+// 流式请求在done事件之后以subject事件推送主题，其余请求在后台生成，刷新会话列表后可见
+func generateSubjectAfterReply(ctx *gin.Context, chatRecord *chat.Record, text string) {
+	if isStream(ctx) {
+		subject, err := generateSubject(ctx, chatRecord, text)
+		if err != nil {
+			if !errors.Is(err, errSubjectChanged) {
+				logger.Warning("generate subject error:", err)
+			}
+			return
+		}
+		pushEvent(ctx, "subject", gin.H{"ChatID": chatRecord.ChatID, "Subject": subject})
+		return
+	}
+
+	background, cancel := detachContext(ctx, subjectTimeout)
+	go func() {
+		defer cancel()
+		if _, err := generateSubject(background, chatRecord, text); err != nil && !errors.Is(err, errSubjectChanged) {
+			logger.Warning("generate subject error:", err)
+		}
+	}()
+}
+
+// RegenerateSubject 根据会话的第一条消息重新生成主题
+func (c *ChatController) RegenerateSubject(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	path, err := chat.SelectPath(chatRecord)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	text := ""
+	for _, item := range path {
+		if item.Role == gogpt.ChatMessageRoleUser {
+			text = item.Content
+			break
+		}
+	}
+	if text == "" {
+		c.ResponseJson(ctx, customErrorCode, "会话中没有可以生成主题的消息", nil)
+		return
+	}
+	subject, err := generateSubject(ctx, chatRecord, text)
+	if err != nil {
+		c.responseError(ctx, err)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Subject": subject,
+	})
+}
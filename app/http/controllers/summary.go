@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// summaryPrefix 发送给上游时摘要的前缀
+const summaryPrefix = "以下是之前对话的摘要：\n"
+
+// summaryPrompt 生成摘要的提示词，依次填入已有摘要和新的对话
+const summaryPrompt = "已有摘要：\n%s\n\n新的对话：\n%s\n请将已有摘要和新的对话合并为一份简洁的摘要，保留关键事实、结论和用户的偏好，不超过%d个字，直接输出摘要内容。"
+
+// summaryReserve 开启摘要时为摘要在上下文窗口中预留的token数
+func summaryReserve(model string) int {
+	cnf := config.LoadConfig()
+	if !cnf.ContextSummary {
+		return 0
+	}
+	return cnf.SummaryMaxTokens + tokenizer.CountMessage(model, gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleSystem, Content: summaryPrefix})
+}
+
+// contextSummary 返回代替被丢弃对话发送的摘要，已有摘要未涵盖全部被丢弃的对话时增量更新
+// 摘要失败时不影响本次回复，沿用已有摘要
+func contextSummary(ctx *gin.Context, chatRecord *chat.Record, kept []*chat.Message, dropped []*chat.Message) string {
+	if !config.LoadConfig().ContextSummary || chatRecord == nil || len(dropped) == 0 {
+		return ""
+	}
+
+	summary := chatRecord.Summary
+	pending := dropped
+	if chatRecord.SummaryUntilID != 0 {
+		if i := indexOfMessage(dropped, chatRecord.SummaryUntilID); i >= 0 {
+			pending = dropped[i+1:]
+		} else if indexOfMessage(kept, chatRecord.SummaryUntilID) >= 0 {
+			pending = nil
+		} else {
+			// 摘要属于其他分支，重新总结当前分支
+			summary = ""
+		}
+	}
+	if len(pending) == 0 {
+		return summary
+	}
+
+	updated, err := summarize(ctx, chatRecord.ChatID, summary, pending)
+	if err != nil {
+		logger.Warning("summarize chat error:", err)
+		return summary
+	}
+	if err = chat.UpdateSummary(chatRecord, updated, pending[len(pending)-1].ID); err != nil {
+		logger.Warning("update summary error:", err)
+	}
+	return updated
+}
+
+// summarize 使用摘要模型将已有摘要和新的对话合并为新的摘要
+func summarize(ctx *gin.Context, chatID string, summary string, messages []*chat.Message) (string, error) {
+	cnf := config.LoadConfig()
+	var builder strings.Builder
+	for _, item := range messages {
+		builder.WriteString(fmt.Sprintf("%s：%s\n", roleName(item.Role), item.Content))
+	}
+	if summary == "" {
+		summary = "无"
+	}
+
+	request := gogpt.ChatCompletionRequest{
+		Model:     cnf.SummaryModel,
+		MaxTokens: cnf.SummaryMaxTokens,
+		Messages: []gogpt.ChatCompletionMessage{
+			{Role: gogpt.ChatMessageRoleSystem, Content: "你负责总结对话内容。"},
+			{Role: gogpt.ChatMessageRoleUser, Content: fmt.Sprintf(summaryPrompt, summary, builder.String(), cnf.SummaryMaxTokens)},
+		},
+	}
+	result, err := complete(ctx, request, chatID, usage.KindSummary)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Message.Content), nil
+}
+
+// withSummary 在系统提示词之后插入摘要
+func withSummary(ctx *gin.Context, messages []gogpt.ChatCompletionMessage, summary string) []gogpt.ChatCompletionMessage {
+	result := make([]gogpt.ChatCompletionMessage, 0, len(messages)+2)
+	if len(messages) > 0 && messages[0].Role == gogpt.ChatMessageRoleSystem {
+		result = append(result, messages[0])
+		messages = messages[1:]
+	} else {
+		// 首条消息为系统消息时CreateChatCompletion不再添加系统提示词，这里先补上
+		result = append(result, gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleSystem, Content: systemPrompt(ctx)})
+	}
+	result = append(result, gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleSystem, Content: summaryPrefix + summary})
+	return append(result, messages...)
+}
+
+// roleName 消息角色在摘要中的名称
+func roleName(role string) string {
+	switch role {
+	case gogpt.ChatMessageRoleUser:
+		return "用户"
+	case gogpt.ChatMessageRoleAssistant:
+		return "助手"
+	default:
+		return "系统"
+	}
+}
+
+// indexOfMessage 查找消息在列表中的位置，不存在时返回-1
+func indexOfMessage(messages []*chat.Message, id uint64) int {
+	for i, item := range messages {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Summary 获取会话的摘要
+func (c *ChatController) Summary(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Summary":        chatRecord.Summary,
+		"SummaryUntilID": chatRecord.SummaryUntilID,
+	})
+}
+
+// SetSummary 修改会话的摘要，内容为空时清空摘要，下次超出上下文窗口时重新总结
+func (c *ChatController) SetSummary(ctx *gin.Context) {
+	var request ChatRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, chatRecord, ok := c.ownChatRecord(ctx, request.ChatID)
+	if !ok {
+		return
+	}
+
+	summary := strings.TrimSpace(request.Content)
+	untilId := chatRecord.SummaryUntilID
+	if summary == "" {
+		untilId = 0
+	}
+	if err = chat.UpdateSummary(chatRecord, summary, untilId); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
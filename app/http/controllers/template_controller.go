@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/869413421/chatgpt-web/pkg/model/prompt"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// TemplateController 提示词模板控制器
+type TemplateController struct {
+	BaseController
+}
+
+func NewTemplateController() *TemplateController {
+	return &TemplateController{}
+}
+
+// templateRequest 模板请求
+type templateRequest struct {
+	ID          uint64            `json:"id"`
+	IDs         []uint64          `json:"ids"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Content     string            `json:"content"`
+	Tags        string            `json:"tags"`
+	Tag         string            `json:"tag"`
+	Shared      bool              `json:"shared"`
+	Values      map[string]string `json:"values"`
+}
+
+// templateFile 导入导出的模板，不包含ID和所属用户
+type templateFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+	Tags        string `json:"tags"`
+}
+
+// templateImportRequest 导入模板的请求，内容为导出的JSON文件
+type templateImportRequest struct {
+	Templates []templateFile `json:"templates"`
+	Shared    bool           `json:"shared,omitempty"`
+}
+
+// templateCompletionRequest 使用模板发送消息的请求，其余字段与/chat/completion相同
+type templateCompletionRequest struct {
+	TemplateID uint64            `json:"templateid"`
+	Values     map[string]string `json:"values"`
+	ChatRequest
+}
+
+// List 获取自己的及共享的模板，可按标签筛选
+func (c *TemplateController) List(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req templateRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	templates, err := prompt.List(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	result := make([]gin.H, 0, len(templates))
+	for _, item := range templates {
+		if req.Tag != "" && !item.HasTag(req.Tag) {
+			continue
+		}
+		result = append(result, gin.H{
+			"ID":          item.ID,
+			"UserID":      item.UserID,
+			"Name":        item.Name,
+			"Description": item.Description,
+			"Content":     item.Content,
+			"Tags":        item.Tags,
+			"Shared":      item.Shared,
+			"Variables":   item.Variables(),
+			"UpdatedAt":   item.UpdatedAt,
+		})
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Templates": result,
+	})
+}
+
+// Save 新建或修改模板
+func (c *TemplateController) Save(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req templateRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	item := &prompt.Template{UserID: userInfo.ID}
+	if req.ID != 0 {
+		var ok bool
+		if item, ok = c.ownTemplate(ctx, req.ID); !ok {
+			return
+		}
+	}
+	file := templateFile{Name: req.Name, Description: req.Description, Content: req.Content, Tags: req.Tags}
+	if err = file.apply(item); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	item.Shared = req.Shared
+	if err = prompt.Save(item); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Template": item,
+	})
+}
+
+// Delete 删除模板
+func (c *TemplateController) Delete(ctx *gin.Context) {
+	var req templateRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	item, ok := c.ownTemplate(ctx, req.ID)
+	if !ok {
+		return
+	}
+	if err = prompt.Delete(item.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// Render 预览填入变量后的模板内容
+func (c *TemplateController) Render(ctx *gin.Context) {
+	var req templateRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	content, err := renderTemplate(ctx, req.ID, req.Values)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Content": content,
+	})
+}
+
+// Export 导出模板为JSON文件，不传ids时导出自己的全部模板
+func (c *TemplateController) Export(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req templateRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	templates, err := prompt.List(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	selected := make(map[uint64]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		selected[id] = true
+	}
+	files := make([]templateFile, 0, len(templates))
+	for _, item := range templates {
+		if (len(selected) == 0 && item.UserID != userInfo.ID) || (len(selected) > 0 && !selected[item.ID]) {
+			continue
+		}
+		files = append(files, templateFile{Name: item.Name, Description: item.Description, Content: item.Content, Tags: item.Tags})
+	}
+	data, err := json.MarshalIndent(templateImportRequest{Templates: files}, "", "  ")
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=templates-%s.json", time.Now().Format("20060102150405")))
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// Import 导入JSON文件中的模板，全部作为自己的新模板保存
+func (c *TemplateController) Import(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req templateImportRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if len(req.Templates) == 0 {
+		c.ResponseJson(ctx, customErrorCode, "没有可以导入的模板", nil)
+		return
+	}
+
+	templates := make([]*prompt.Template, 0, len(req.Templates))
+	for i, file := range req.Templates {
+		item := &prompt.Template{UserID: userInfo.ID, Shared: req.Shared}
+		if err = file.apply(item); err != nil {
+			c.ResponseJson(ctx, customErrorCode, fmt.Sprintf("第%d个模板：%s", i+1, err.Error()), nil)
+			return
+		}
+		templates = append(templates, item)
+	}
+	if err = prompt.Create(templates); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Templates": templates,
+	})
+}
+
+// apply 校验模板内容并设置到item中
+func (f templateFile) apply(item *prompt.Template) error {
+	name := strings.TrimSpace(f.Name)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		return fmt.Errorf("模板名称不能为空且不能超过64个字")
+	}
+	if strings.TrimSpace(f.Content) == "" {
+		return fmt.Errorf("模板内容不能为空")
+	}
+	tags := prompt.NormalizeTags(f.Tags)
+	if len(tags) > 255 || utf8.RuneCountInString(f.Description) > 255 {
+		return fmt.Errorf("标签或描述过长")
+	}
+	item.Name = name
+	item.Description = f.Description
+	item.Content = f.Content
+	item.Tags = tags
+	return nil
+}
+
+// ownTemplate 查询可以修改的模板，自己的模板或管理员修改共享模板，失败时直接返回错误响应
+func (c *TemplateController) ownTemplate(ctx *gin.Context, id uint64) (*prompt.Template, bool) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return nil, false
+	}
+	item, err := prompt.Get(id)
+	if err != nil || !item.VisibleTo(userInfo.ID) {
+		c.ResponseJson(ctx, customErrorCode, "模板不存在", nil)
+		return nil, false
+	}
+	if item.UserID != userInfo.ID && !userInfo.IsAdmin {
+		c.ResponseJson(ctx, http.StatusForbidden, "只能修改自己创建的模板", nil)
+		return nil, false
+	}
+	return item, true
+}
+
+// renderTemplate 用values填充当前用户可用的模板
+func renderTemplate(ctx *gin.Context, id uint64, values map[string]string) (string, error) {
+	userInfo := GetLoginUser(ctx)
+	item, err := prompt.Get(id)
+	if err != nil || userInfo == nil || !item.VisibleTo(userInfo.ID) {
+		return "", fmt.Errorf("模板不存在")
+	}
+	return item.Render(values)
+}
+
+// TemplateCompletion 填充模板后作为用户消息发送，chatid对应的会话不存在时创建新会话
+func (c *ChatController) TemplateCompletion(ctx *gin.Context) {
+	var request templateCompletionRequest
+	err := ctx.BindJSON(&request)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if request.Stream {
+		requestStream(ctx)
+	}
+	content, err := renderTemplate(ctx, request.TemplateID, request.Values)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	request.Messages = []gogpt.ChatCompletionMessage{{Role: gogpt.ChatMessageRoleUser, Content: content}}
+	c.completion(ctx, &request.ChatRequest)
+}
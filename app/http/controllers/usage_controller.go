@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+	"github.com/gin-gonic/gin"
+)
+
+// UsageController 用量统计控制器
+type UsageController struct {
+	BaseController
+}
+
+func NewUsageController() *UsageController {
+	return &UsageController{}
+}
+
+// usageRequest 用量统计请求
+type usageRequest struct {
+	Period string `json:"period"` // day或month，默认day
+	Start  string `json:"start"`  // 开始日期，按天为2006-01-02，按月为2006-01
+	End    string `json:"end"`    // 结束日期，格式同上
+	UserID uint64 `json:"userid"` // 只统计指定用户，0表示全部
+}
+
+// UserUsage 按用户统计用量
+func (c *UsageController) UserUsage(ctx *gin.Context) {
+	var req usageRequest
+	if !c.bindUsageRequest(ctx, &req) {
+		return
+	}
+
+	items, err := usage.AggregateByUser(req.Period, req.Start, req.End, req.UserID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"period": req.Period,
+		"items":  items,
+	})
+}
+
+// ModelUsage 按模型统计用量
+func (c *UsageController) ModelUsage(ctx *gin.Context) {
+	var req usageRequest
+	if !c.bindUsageRequest(ctx, &req) {
+		return
+	}
+
+	items, err := usage.AggregateByModel(req.Period, req.Start, req.End)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"period": req.Period,
+		"items":  items,
+	})
+}
+
+// AppUsage 按应用统计用量
+func (c *UsageController) AppUsage(ctx *gin.Context) {
+	var req usageRequest
+	if !c.bindUsageRequest(ctx, &req) {
+		return
+	}
+
+	items, err := usage.AggregateByApp(req.Period, req.Start, req.End)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"period": req.Period,
+		"items":  items,
+	})
+}
+
+// bindUsageRequest 解析并校验统计请求
+func (c *UsageController) bindUsageRequest(ctx *gin.Context, req *usageRequest) bool {
+	err := ctx.BindJSON(req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return false
+	}
+
+	if req.Period == "" {
+		req.Period = usage.PeriodDay
+	}
+	if req.Period != usage.PeriodDay && req.Period != usage.PeriodMonth {
+		c.ResponseJson(ctx, customErrorCode, "统计周期只能是day或month", nil)
+		return false
+	}
+	return true
+}
@@ -1,10 +1,25 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/auth"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/mail"
 	"github.com/869413421/chatgpt-web/pkg/model/user"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	resetPasswordTTL = 30 * time.Minute
+	verifyEmailTTL   = 24 * time.Hour
 )
 
 // UserController 用户控制器
@@ -21,6 +36,8 @@ type userRequest struct {
 	Name        string `json:"username"`
 	Password    string `json:"password"`
 	NewPassword string `json:"newpassword"`
+	Email       string `json:"email"`
+	Token       string `json:"token"`
 }
 
 // 修改密码
@@ -42,7 +59,7 @@ func (c *UserController) UpdatePassword(ctx *gin.Context) {
 
 	// 判断用户密码是否符合复杂性要求
 	if !user.IsComplexPassword(req.NewPassword) {
-		c.ResponseJson(ctx, customErrorCode, "用户新密码至少8位，且包含大小写字母、数字", nil)
+		c.ResponseJson(ctx, customErrorCode, "用户新密码需为8到72位，且包含大小写字母、数字", nil)
 		return
 	}
 
@@ -97,7 +114,7 @@ func (c *UserController) CreateUser(ctx *gin.Context) {
 
 	// 判断用户密码是否符合复杂性要求
 	if !user.IsComplexPassword(req.Password) {
-		c.ResponseJson(ctx, customErrorCode, "用户密码至少8位，且包含大小写字母、数字", nil)
+		c.ResponseJson(ctx, customErrorCode, "用户密码需为8到72位，且包含大小写字母、数字", nil)
 		return
 	}
 
@@ -113,8 +130,22 @@ func (c *UserController) CreateUser(ctx *gin.Context) {
 		return
 	}
 
+	if req.Email != "" {
+		if !user.IsEmail(req.Email) {
+			c.ResponseJson(ctx, customErrorCode, "邮箱格式不正确", nil)
+			return
+		}
+		if _, err = user.GetByEmail(req.Email); err == nil {
+			c.ResponseJson(ctx, customErrorCode, "该邮箱已被其他用户使用", nil)
+			return
+		}
+	}
+
 	// 新增用户
-	_, err = user.CreateUser(req.Name, req.Password, false)
+	newUser, err := user.CreateUser(req.Name, req.Password, false)
+	if err == nil && req.Email != "" {
+		_, err = user.UpdateEmail(newUser.ID, req.Email)
+	}
 	if err != nil {
 		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
 		return
@@ -123,3 +154,332 @@ func (c *UserController) CreateUser(ctx *gin.Context) {
 		return
 	}
 }
+
+// ForgotPassword 忘记密码，向已验证的邮箱发送重置链接
+func (c *UserController) ForgotPassword(ctx *gin.Context) {
+	var req userRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Name == "" && req.Email == "" {
+		c.ResponseJson(ctx, customErrorCode, "请输入用户名或邮箱", nil)
+		return
+	}
+	if !mail.Enabled() {
+		c.ResponseJson(ctx, customErrorCode, "系统未配置邮件服务，请联系管理员重置密码", nil)
+		return
+	}
+	if siteURL() == "" {
+		c.ResponseJson(ctx, customErrorCode, "系统未配置站点地址，请联系管理员重置密码", nil)
+		return
+	}
+
+	var userInfo *user.User
+	if req.Name != "" {
+		userInfo, err = user.GetByName(req.Name)
+	} else {
+		userInfo, err = user.GetByEmail(req.Email)
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	// 无论用户是否存在都返回相同结果，避免泄露账号信息
+	if err == nil && userInfo.Email != "" && userInfo.EmailVerified {
+		token, err := auth.EncodeAction(userInfo, auth.ActionResetPassword, resetPasswordTTL)
+		if err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+			return
+		}
+		link := siteURL() + "/resetpassword?token=" + url.QueryEscape(token)
+		body := fmt.Sprintf("%s，您好：\n\n请在%d分钟内打开以下链接重置密码，链接仅可使用一次：\n%s\n\n如果不是您本人操作，请忽略本邮件。",
+			userInfo.Name, int(resetPasswordTTL.Minutes()), link)
+		if err = mail.Send(userInfo.Email, "重置密码", body); err != nil {
+			logger.Warning("send reset password mail error:", err)
+			c.ResponseJson(ctx, customErrorCode, "邮件发送失败，请稍后再试", nil)
+			return
+		}
+	}
+
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// ResetPassword 通过邮件中的链接重置密码
+func (c *UserController) ResetPassword(ctx *gin.Context) {
+	var req userRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Token == "" || req.NewPassword == "" {
+		c.ResponseJson(ctx, customErrorCode, "重置令牌和新密码不能为空", nil)
+		return
+	}
+	if !user.IsComplexPassword(req.NewPassword) {
+		c.ResponseJson(ctx, customErrorCode, "用户新密码需为8到72位，且包含大小写字母、数字", nil)
+		return
+	}
+
+	userInfo, err := auth.DecodeAction(req.Token, auth.ActionResetPassword)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	_, err = user.UpdatePassword(userInfo.Name, req.NewPassword)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// SetParams 设置自己默认的模型及参数，优先于用户组和全局的设置
+func (c *UserController) SetParams(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req paramsRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	params, err := req.toParams()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	profile, err := user.SaveParams(userInfo.ID, params)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Params": profile.Params,
+	})
+}
+
+// profileRequest 保存个人设置的请求
+type profileRequest struct {
+	DisplayName  string `json:"displayname"`
+	Avatar       string `json:"avatar"`
+	Language     string `json:"language"`
+	SystemPrompt string `json:"system_prompt"`
+	SendOnEnter  bool   `json:"send_on_enter"`
+	Theme        string `json:"theme"`
+	paramsRequest
+}
+
+// Profile 获取自己的个人设置
+func (c *UserController) Profile(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	profile, err := user.GetProfile(userInfo.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Profile": profile,
+	})
+}
+
+// SaveProfile 保存自己的个人设置，保存在服务端以便在不同浏览器间同步
+func (c *UserController) SaveProfile(ctx *gin.Context) {
+	userInfo := GetLoginUser(ctx)
+	if userInfo == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	var req profileRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	if utf8.RuneCountInString(req.DisplayName) > 64 {
+		c.ResponseJson(ctx, customErrorCode, "昵称不能超过64个字", nil)
+		return
+	}
+	if len(req.Avatar) > 512 {
+		c.ResponseJson(ctx, customErrorCode, "头像地址过长", nil)
+		return
+	}
+	if len(req.Language) > 32 || len(req.Theme) > 32 {
+		c.ResponseJson(ctx, customErrorCode, "语言或主题设置不正确", nil)
+		return
+	}
+	params, err := req.toParams()
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	profile := &user.Profile{
+		UserID:       userInfo.ID,
+		DisplayName:  req.DisplayName,
+		Avatar:       strings.TrimSpace(req.Avatar),
+		Language:     req.Language,
+		SystemPrompt: strings.TrimSpace(req.SystemPrompt),
+		SendOnEnter:  req.SendOnEnter,
+		Theme:        req.Theme,
+		Params:       params,
+	}
+	if err = user.SaveProfile(profile); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", gin.H{
+		"Profile": profile,
+	})
+}
+
+// UpdateEmail 绑定或修改自己的邮箱，并发送验证邮件
+func (c *UserController) UpdateEmail(ctx *gin.Context) {
+	var req userRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if !user.IsEmail(req.Email) {
+		c.ResponseJson(ctx, customErrorCode, "邮箱格式不正确", nil)
+		return
+	}
+
+	loginUser := GetLoginUser(ctx)
+	if loginUser == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+	if mail.Enabled() && siteURL() == "" {
+		c.ResponseJson(ctx, customErrorCode, "系统未配置站点地址，无法发送验证邮件", nil)
+		return
+	}
+
+	exists, err := user.GetByEmail(req.Email)
+	if err == nil && exists.ID != loginUser.ID {
+		c.ResponseJson(ctx, customErrorCode, "该邮箱已被其他用户使用", nil)
+		return
+	}
+
+	userInfo, err := user.UpdateEmail(loginUser.ID, req.Email)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if mail.Enabled() {
+		if err = c.sendVerifyEmail(ctx, userInfo); err != nil {
+			c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+			return
+		}
+	}
+
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// SendVerifyEmail 重新发送邮箱验证邮件
+func (c *UserController) SendVerifyEmail(ctx *gin.Context) {
+	loginUser := GetLoginUser(ctx)
+	if loginUser == nil {
+		c.ResponseJson(ctx, http.StatusUnauthorized, "未登录", nil)
+		return
+	}
+
+	userInfo, err := user.GetByID(loginUser.ID)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, "获取用户信息失败"+err.Error(), nil)
+		return
+	}
+	if userInfo.Email == "" {
+		c.ResponseJson(ctx, customErrorCode, "请先绑定邮箱", nil)
+		return
+	}
+	if userInfo.EmailVerified {
+		c.ResponseJson(ctx, customErrorCode, "邮箱已验证", nil)
+		return
+	}
+	if !mail.Enabled() {
+		c.ResponseJson(ctx, customErrorCode, "系统未配置邮件服务", nil)
+		return
+	}
+	if siteURL() == "" {
+		c.ResponseJson(ctx, customErrorCode, "系统未配置站点地址，无法发送验证邮件", nil)
+		return
+	}
+
+	if err = c.sendVerifyEmail(ctx, userInfo); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// VerifyEmail 通过邮件中的链接完成邮箱验证
+func (c *UserController) VerifyEmail(ctx *gin.Context) {
+	var req userRequest
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	if req.Token == "" {
+		c.ResponseJson(ctx, customErrorCode, "验证令牌不能为空", nil)
+		return
+	}
+
+	userInfo, err := auth.DecodeAction(req.Token, auth.ActionVerifyEmail)
+	if err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+	if _, err = user.VerifyEmail(userInfo.ID); err != nil {
+		c.ResponseJson(ctx, customErrorCode, err.Error(), nil)
+		return
+	}
+
+	c.ResponseJson(ctx, http.StatusOK, "", nil)
+}
+
+// sendVerifyEmail 发送邮箱验证邮件
+func (c *UserController) sendVerifyEmail(ctx *gin.Context, userInfo *user.User) error {
+	token, err := auth.EncodeAction(userInfo, auth.ActionVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	site := siteURL()
+	if site == "" {
+		return fmt.Errorf("系统未配置站点地址，无法发送验证邮件")
+	}
+	link := site + "/verifyemail?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("%s，您好：\n\n请在%d小时内打开以下链接完成邮箱验证：\n%s\n\n如果不是您本人操作，请忽略本邮件。",
+		userInfo.Name, int(verifyEmailTTL.Hours()), link)
+	if err = mail.Send(userInfo.Email, "验证邮箱", body); err != nil {
+		logger.Warning("send verify mail error:", err)
+		return fmt.Errorf("邮件发送失败，请稍后再试")
+	}
+	return nil
+}
+
+// siteURL 获取配置的站点访问地址，用于生成邮件中的链接，未配置时返回空
+// 不能使用请求的Host生成链接，否则攻击者伪造Host即可让找回密码邮件指向自己的站点
+func siteURL() string {
+	return strings.TrimRight(config.LoadConfig().SiteURL, "/")
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/tokenizer"
+	"github.com/gin-gonic/gin"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// contextMargin 上下文窗口中不使用的比例（百分比）
+// 兼容接口的其他模型使用的编码可能与计算时不同，消息格式的开销也因模型而异，预留余量避免超出窗口
+const contextMargin = 5
+
+// fitContext 按模型的上下文窗口裁剪历史消息，从最早的对话开始丢弃
+// 系统提示词和最新一条消息始终保留，窗口需要同时容纳本次的新消息、回复预留的max_tokens、reserve以及contextMargin
+func fitContext(ctx *gin.Context, request gogpt.ChatCompletionRequest, history []*chat.Message, newMessages []gogpt.ChatCompletionMessage, reserve int) (kept []*chat.Message, dropped []*chat.Message) {
+	cnf := config.LoadConfig()
+	model, err := resolveModel(request.Model)
+	if err != nil {
+		// 模型不可用时由complete返回错误
+		return history, nil
+	}
+	window := cnf.FindModel(model).Window()
+	budget := window - window*contextMargin/100 - replyMaxTokens(request) - tokenizer.CountMessages(model, newMessages) - reserve
+
+	// 保存的历史中带有系统提示词时保留，否则预留CreateChatCompletion中添加的系统提示词
+	var system, latest []*chat.Message
+	if len(history) > 0 && history[0].Role == gogpt.ChatMessageRoleSystem {
+		system, history = history[:1], history[1:]
+	} else {
+		budget -= tokenizer.CountMessage(model, gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleSystem, Content: systemPrompt(ctx)})
+	}
+	// 重新生成回复时没有新消息，历史中的最后一条即为最新的问题
+	if len(newMessages) == 0 && len(history) > 0 {
+		history, latest = history[:len(history)-1], history[len(history)-1:]
+	}
+	for _, item := range append(append([]*chat.Message{}, system...), latest...) {
+		budget -= messageTokens(model, item)
+	}
+
+	total := 0
+	for _, item := range history {
+		total += messageTokens(model, item)
+	}
+	start := 0
+	for start < len(history) && total > budget {
+		total -= messageTokens(model, history[start])
+		start++
+	}
+	// 丢弃后从完整的一轮对话开始，避免以回复开头
+	for start > 0 && start < len(history) && history[start].Role != gogpt.ChatMessageRoleUser {
+		start++
+	}
+
+	kept = make([]*chat.Message, 0, len(system)+len(history)-start+len(latest))
+	kept = append(kept, system...)
+	kept = append(kept, history[start:]...)
+	kept = append(kept, latest...)
+	return kept, history[:start]
+}
+
+// fitMessages 按上下文窗口裁剪history后与newMessages拼接为发送给上游的消息，开启摘要时被丢弃的对话以摘要代替
+// reserve为之后追加到消息中的内容（如续写时已输出的回复）预留的token数，返回被丢弃的消息及使用的摘要
+func fitMessages(ctx *gin.Context, request gogpt.ChatCompletionRequest, chatRecord *chat.Record, history []*chat.Message,
+	newMessages []gogpt.ChatCompletionMessage, reserve int) (messages []gogpt.ChatCompletionMessage, dropped []*chat.Message, summary string) {
+	history, dropped = fitContext(ctx, request, history, newMessages, reserve+summaryReserve(request.Model))
+	summary = contextSummary(ctx, chatRecord, history, dropped)
+	messages = append(toCompletionMessages(history), newMessages...)
+	if summary != "" {
+		messages = withSummary(ctx, messages, summary)
+	}
+	return
+}
+
+// replyMaxTokens 回复的最大token数，请求未指定时使用全局配置
+func replyMaxTokens(request gogpt.ChatCompletionRequest) int {
+	if request.MaxTokens != 0 {
+		return request.MaxTokens
+	}
+	return config.LoadConfig().MaxTokens
+}
+
+// messageTokens 计算保存的消息发送给上游时的token数
+func messageTokens(model string, message *chat.Message) int {
+	return tokenizer.CountMessage(model, gogpt.ChatCompletionMessage{Role: message.Role, Content: message.Content})
+}
+
+// countTurns 统计消息中的对话轮数，以用户消息计数
+func countTurns(messages []gogpt.ChatCompletionMessage) int {
+	turns := 0
+	for _, item := range messages {
+		if item.Role == gogpt.ChatMessageRoleUser {
+			turns++
+		}
+	}
+	return turns
+}
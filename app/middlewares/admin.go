@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/869413421/chatgpt-web/app/http/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+// Admin 管理员权限校验，需要在Jwt之后使用
+func Admin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInfo := controllers.GetLoginUser(c)
+		if userInfo == nil {
+			base.ResponseJson(c, http.StatusUnauthorized, "未登录", nil)
+			return
+		}
+
+		if !userInfo.IsAdmin {
+			base.ResponseJson(c, http.StatusForbidden, "您不是管理员，无权进行此操作", nil)
+			return
+		}
+		c.Next()
+	}
+}
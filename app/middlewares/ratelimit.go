@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/869413421/chatgpt-web/app/http/controllers"
+	"github.com/869413421/chatgpt-web/config"
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/ratelimit"
+	"github.com/869413421/chatgpt-web/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitIP 按客户端IP限流，用于登录等无需认证的接口
+func RateLimitIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := config.LoadConfig().RateLimitIP
+		err := ratelimit.Take("ip:"+c.ClientIP(), ratelimit.PerMinute(limit.Requests, limit.Burst))
+		abortIfLimited(c, err)
+	}
+}
+
+// RateLimitUser 按登录用户限流，需要在Jwt之后使用
+func RateLimitUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInfo := controllers.GetLoginUser(c)
+		if userInfo == nil {
+			c.Next()
+			return
+		}
+		limit := config.LoadConfig().RateLimitUser
+		err := ratelimit.Take("user:"+types.UInt64ToString(userInfo.ID), ratelimit.PerMinute(limit.Requests, limit.Burst))
+		abortIfLimited(c, err)
+	}
+}
+
+// abortIfLimited 被限流时返回429及Retry-After，限流存储出错时放行
+func abortIfLimited(c *gin.Context, err error) {
+	if limited, ok := err.(*ratelimit.LimitedError); ok {
+		c.Header("Retry-After", strconv.Itoa(limited.Seconds()))
+		base.ResponseJson(c, http.StatusTooManyRequests, limited.Error(), nil)
+		return
+	}
+	if err != nil {
+		logger.Warning("rate limit error:", err)
+	}
+	c.Next()
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/869413421/chatgpt-web/config"
 	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/mail"
 	"github.com/gin-gonic/gin"
 )
 
@@ -26,8 +27,10 @@ func StartWebServer() {
 	// 注册启动所需各类参数
 	SetUpRoute()
 	SetupDB()
+	SetupScheduler()
 	initTemplateDir()
 	initStaticServer()
+	checkSiteURL()
 
 	// 启动服务
 	port := config.LoadConfig().Port
@@ -41,6 +44,13 @@ func StartWebServer() {
 	}
 }
 
+// checkSiteURL 开启邮件服务时必须配置站点地址，否则不发送找回密码和邮箱验证邮件
+func checkSiteURL() {
+	if mail.Enabled() && config.LoadConfig().SiteURL == "" {
+		logger.Warning("site_url is required when smtp_host is set, password reset and verification mails will not be sent")
+	}
+}
+
 // initTemplate 初始化HTML模板加载路径
 func initTemplateDir() {
 	if isRelease {
@@ -4,7 +4,16 @@ import (
 	"github.com/869413421/chatgpt-web/config"
 	"github.com/869413421/chatgpt-web/pkg/logger"
 	"github.com/869413421/chatgpt-web/pkg/model"
+	"github.com/869413421/chatgpt-web/pkg/model/app"
 	"github.com/869413421/chatgpt-web/pkg/model/chat"
+	"github.com/869413421/chatgpt-web/pkg/model/credit"
+	"github.com/869413421/chatgpt-web/pkg/model/embedding"
+	"github.com/869413421/chatgpt-web/pkg/model/group"
+	"github.com/869413421/chatgpt-web/pkg/model/memory"
+	"github.com/869413421/chatgpt-web/pkg/model/persona"
+	"github.com/869413421/chatgpt-web/pkg/model/prompt"
+	"github.com/869413421/chatgpt-web/pkg/model/quota"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
 	"github.com/869413421/chatgpt-web/pkg/model/user"
 	"gorm.io/gorm"
 )
@@ -23,10 +32,27 @@ func SetupDB() {
 
 // migration 迁移
 func migration(db *gorm.DB) {
-	err := db.AutoMigrate(&user.User{}, &chat.Record{})
+	err := db.AutoMigrate(&user.User{}, &chat.Record{}, &usage.Usage{}, &group.Group{},
+		&quota.Budget{}, &quota.Override{}, &credit.Account{}, &credit.Ledger{}, &credit.RedeemCode{},
+		&chat.Message{}, &memory.Memory{}, &user.Profile{}, &persona.Persona{}, &prompt.Template{}, &app.App{},
+		&chat.Folder{}, &chat.Tag{}, &embedding.Embedding{}, &chat.Share{})
 	if err != nil {
 		logger.Danger("migration model error:", err)
 	}
+
+	// 旧版本的聊天记录以JSON保存在records.messages中，迁移到消息表
+	migrated, err := chat.MigrateMessages()
+	if err != nil {
+		logger.Danger("migration chat messages error:", err)
+	}
+	if migrated > 0 {
+		logger.Info("migrated chat messages of", migrated, "records")
+	}
+
+	// 全文索引创建失败时仍可启动，搜索退化为模糊匹配
+	if err = chat.SetupSearch(); err != nil {
+		logger.Warning("setup chat search error, fall back to LIKE search:", err)
+	}
 }
 
 // 插入管理用户
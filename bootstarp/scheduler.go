@@ -0,0 +1,14 @@
+package bootstrap
+
+import (
+	"github.com/869413421/chatgpt-web/app/http/controllers"
+	"github.com/869413421/chatgpt-web/pkg/model/quota"
+)
+
+// SetupScheduler 启动后台定时任务
+func SetupScheduler() {
+	// 预算周期重置
+	quota.StartScheduler()
+	// 为新消息计算向量，用于语义搜索
+	controllers.StartEmbeddingWorker()
+}
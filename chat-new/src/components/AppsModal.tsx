@@ -0,0 +1,129 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Empty, Input, List, Modal, Select, Space, Spin, message } from 'antd';
+import { getApps, runApp } from '../services/port';
+
+// 应用表单中的字段
+interface AppField {
+  name: string;
+  label: string;
+  // text、textarea或select
+  type: string;
+  options?: string[];
+  required: boolean;
+}
+
+interface AppItem {
+  ID: number;
+  Key: string;
+  Name: string;
+  Description: string;
+  Fields: AppField[] | null;
+}
+
+interface AppsModalProps {
+  open: boolean;
+  onClose: () => void;
+}
+
+// 应用列表，选择应用后填写表单运行，每次运行相互独立，不保存为会话
+export const AppsModal: React.FC<AppsModalProps> = ({ open, onClose }) => {
+  const [apps, setApps] = useState<AppItem[]>([]);
+  const [loading, setLoading] = useState(false);
+  const [current, setCurrent] = useState<AppItem | null>(null);
+  const [values, setValues] = useState<Record<string, string>>({});
+  const [running, setRunning] = useState(false);
+  const [reply, setReply] = useState('');
+
+  useEffect(() => {
+    if (!open) {
+      return;
+    }
+    setCurrent(null);
+    setReply('');
+    setLoading(true);
+    getApps().then((res) => {
+      if (res.data.code === 200) {
+        setApps(res.data.data.Apps ?? []);
+      } else {
+        message.error(res.data.errorMsg);
+      }
+    }).finally(() => setLoading(false));
+  }, [open]);
+
+  const handleSelect = (item: AppItem) => {
+    setCurrent(item);
+    setValues({});
+    setReply('');
+  };
+
+  const handleRun = () => {
+    if (current === null) {
+      return;
+    }
+    const missing = (current.Fields ?? []).find((field) => field.required && (values[field.name] ?? '').trim() === '');
+    if (missing) {
+      message.warning('请填写' + (missing.label || missing.name));
+      return;
+    }
+    setRunning(true);
+    runApp(current.Key, values).then((res) => {
+      if (res.data.code === 200) {
+        setReply(res.data.data.Reply);
+      } else {
+        message.error(res.data.errorMsg);
+      }
+    }).finally(() => setRunning(false));
+  };
+
+  const renderField = (field: AppField) => {
+    const value = values[field.name] ?? '';
+    const handleChange = (value: string) => setValues({ ...values, [field.name]: value });
+    switch (field.type) {
+      case 'textarea':
+        return <Input.TextArea rows={4} value={value} onChange={(e) => handleChange(e.target.value)} />;
+      case 'select':
+        return <Select style={{ width: '100%' }} value={value || undefined} onChange={handleChange}
+          options={(field.options ?? []).map((option) => ({ value: option, label: option }))} />;
+      default:
+        return <Input value={value} onChange={(e) => handleChange(e.target.value)} />;
+    }
+  };
+
+  const renderForm = (item: AppItem) => (
+    <Space direction="vertical" style={{ width: '100%' }}>
+      {item.Description ? <div style={{ color: '#8c8c8c' }}>{item.Description}</div> : null}
+      {(item.Fields ?? []).map((field) => (
+        <div key={field.name}>
+          <div style={{ marginBottom: '4px' }}>
+            {field.required ? <span style={{ color: '#ff4d4f' }}>* </span> : null}{field.label || field.name}
+          </div>
+          {renderField(field)}
+        </div>
+      ))}
+      {reply ? <div style={{ whiteSpace: 'pre-wrap', background: '#f5f5f5', padding: '8px 12px', borderRadius: '6px' }}>{reply}</div> : null}
+    </Space>
+  );
+
+  const footer = current === null ? null : (
+    <Space>
+      <Button onClick={() => setCurrent(null)}>返回</Button>
+      <Button type="primary" loading={running} onClick={handleRun}>运行</Button>
+    </Space>
+  );
+
+  return (
+    <Modal title={current === null ? '应用' : current.Name} open={open} onCancel={onClose} footer={footer} destroyOnClose>
+      {current !== null ? renderForm(current) : (
+        <Spin spinning={loading}>
+          {apps.length === 0 && !loading ? <Empty description="暂无可用的应用" /> : (
+            <List dataSource={apps} renderItem={(item) => (
+              <List.Item style={{ cursor: 'pointer' }} onClick={() => handleSelect(item)}>
+                <List.Item.Meta title={item.Name} description={item.Description} />
+              </List.Item>
+            )} />
+          )}
+        </Spin>
+      )}
+    </Modal>
+  );
+};
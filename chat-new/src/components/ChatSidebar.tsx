@@ -18,9 +18,10 @@ interface ChatSidebarProps extends React.HTMLAttributes<HTMLHtmlElement> {
   onNewChatClick?: () => void;
   footerEvent?: (key: string, value: any)  => Promise<any>;
   refreshMenu?: (chatID: string) => void;
+  onLoadMore?: () => void;
 }
 
-export const ChatSidebar: React.FC<ChatSidebarProps> = ({ children, collapsed, toggled, data, onMenuItemClick, onNewChatClick, footerEvent, refreshMenu, ...rest }) => {
+export const ChatSidebar: React.FC<ChatSidebarProps> = ({ children, collapsed, toggled, data, onMenuItemClick, onNewChatClick, footerEvent, refreshMenu, onLoadMore, ...rest }) => {
   const [enterMenuItem, setEnterMenuItem] = useState(-1)
   const [isModalOpen, setIsModalOpen] = useState(false);
   const [subject, setSubject] = useState('');
@@ -31,6 +32,7 @@ export const ChatSidebar: React.FC<ChatSidebarProps> = ({ children, collapsed, t
   let isAdmin = chatData.IsAdmin;
   let userName = chatData.UserName;
   let chatRecord = chatData.ChatRecord;
+  let nextCursor = chatData.NextCursor;
   const theme = 'light'
   const hasImage = true
 
@@ -213,6 +215,7 @@ export const ChatSidebar: React.FC<ChatSidebarProps> = ({ children, collapsed, t
         <div style={{overflowY: 'auto'}}>
             <Menu menuItemStyles={menuItemStyles} ref={menuRef} >
               {renderMenuItems(chatRecord)}
+              {nextCursor ? <MenuItem key="load-more" onClick={() => onLoadMore?.()}>加载更多</MenuItem> : null}
             </Menu>
         </div>
         <SidebarFooter isAdmin={isAdmin} collapsed={collapsed} username={userName} footerEvent={footerEvent} />
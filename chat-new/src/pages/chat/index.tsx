@@ -6,8 +6,9 @@ import clipboardy from 'clipboardy'
 import MdEditor from "md-editor-rt"
 import "md-editor-rt/lib/style.css"
 import sanitizeHtml from 'sanitize-html';
-import {completion, getChatRecord, getChatMessages, isMobileDevice} from '../../services/port'
+import {completion, continueCompletion, getChatRecord, getChatMessages, isMobileDevice} from '../../services/port'
 import { ChatSidebar } from '../../components/ChatSidebar'
+import { AppsModal } from '../../components/AppsModal'
 import {v4 as uuidv4}  from 'uuid'
 import { FloatButton, Layout, message } from 'antd'
 import { LeftOutlined, RightOutlined } from '@ant-design/icons'
@@ -24,6 +25,11 @@ const defaultQuickReplies = [
     isNew: false,
     isHighlight: true,
   },
+  {
+    name: '继续回答',
+    isNew: false,
+    isHighlight: false,
+  },
 ]
 
 const initialMessages = [
@@ -45,9 +51,10 @@ function App() {
   const [collapsed, setCollapsed] = useState(isMobileDevice);
   const [toggled, setToggled] = useState(false);
   const [chatData, setChatData] = useState<{UserID: string, UserName: string, IsAdmin: boolean, ChatRecord: 
-        {ID: number, ChatID: string, Subject: string, CreatedAt: string, UpdatedAt: string}[]}>
+        {ID: number, ChatID: string, Subject: string, CreatedAt: string, UpdatedAt: string}[], NextCursor?: string}>
         ({UserID: '', UserName: '', IsAdmin: false, ChatRecord:[]})
   const [messageApi, contextHolder] = message.useMessage();      
+  const [appsOpen, setAppsOpen] = useState(false);
   
   // 根据角色添加消息
   function appendMessage(role: string, content: string) {
@@ -171,6 +178,21 @@ function App() {
       await clipboardy.write(r)
       toast.success('复制成功', 10_000)
     }
+    if (item.name === '继续回答') {
+      if (percentage > 0) {
+        toast.fail('正在等待上一次回复，请稍后')
+        return
+      }
+      // 续写内容追加在被截断的回复中，完成后重新加载会话
+      setPercentage(10)
+      const res = await continueCompletion(chatContext.chatid)
+      setPercentage(0)
+      if (res.data.code === 200) {
+        handleMenuItemClick(0, chatContext.chatid, chatContext.subject)
+      } else {
+        toast.fail(res.data.errorMsg, 5000)
+      }
+    }
   }
 
   async function onGenCode(question: string) {
@@ -188,21 +210,58 @@ function App() {
       let chatRecord = chatData.ChatRecord
       if (chatRecord === null || chatRecord.length === 0) {
         handleRefreshMenu(res.data.data.ChatRecord[0].ChatID)
+        refreshSubjectLater()
       } else {
         let index = chatRecord.findIndex((item) => item.ChatID === res.data.data.ChatRecord[0].ChatID)
         if (index < 0) {
           handleRefreshMenu(res.data.data.ChatRecord[0].ChatID)
+          refreshSubjectLater()
         } else {
           let reply = clearReply(res.data.data.Reply)
           appendMessage('assistant', reply)
+          if (res.data.data.Incomplete) {
+            toast.show('回复过长已被截断，可点击“继续回答”', undefined)
+          }
         }  
       }
+    } else if (res.data.code === 409) {
+      // 会话已在其他窗口更新，重新加载最新的会话记录
+      toast.fail(res.data.errorMsg, 5000)
+      handleRefreshMenu(chatContext.chatid)
     } else {
       toast.fail('请求出错，' + res.data.errorMsg, 5000)
     }
   }
 
-  function handleMenuItemClick(id: number, chatID: string, subject: string) {
+  // 新会话的主题在回复返回后生成，稍后刷新会话列表
+  function refreshSubjectLater() {
+    setTimeout(() => {
+      getChatRecord().then((res) => {
+        if (res.data.code === 200) {
+          setChatData(res.data.data)
+        }
+      })
+    }, 5000)
+  }
+
+  // 会话列表分页返回，按NextCursor加载下一页追加到列表末尾
+  function handleLoadMore() {
+    if (!chatData.NextCursor) {
+      return
+    }
+    getChatRecord({ cursor: chatData.NextCursor }).then((res) => {
+      if (res.data.code === 200) {
+        setChatData((prev) => ({
+          ...res.data.data,
+          ChatRecord: (prev.ChatRecord ?? []).concat(res.data.data.ChatRecord ?? []),
+        }))
+      } else {
+        toast.fail('请求出错，' + res.data.errorMsg)
+      }
+    })
+  }
+
+  function handleMenuItemClick(id: number, chatID: string, subject: string, messageID?: number) {
     getChatMessages(chatID).then((res) => {
       if (res.data.code === 200) {
         // 清空已有消息
@@ -211,9 +270,16 @@ function App() {
         chatMessages.forEach((item: any) => {
           appendMessage(item.role, item.content)
         })
+        // 旧版本的消息无法迁移时，第一条系统消息中为原始数据
+        if (res.data.data.migrate_error) {
+          toast.show(res.data.data.migrate_error, undefined);
+        }
         chatContext.messages.splice(0)
         chatContext.chatid = chatID
         chatContext.subject = subject
+        if (messageID) {
+          scrollToMessage(chatMessages, messageID)
+        }
       } else {
         toast.fail('请求出错，' + res.data.errorMsg)
         return toast.show('请求出错，' + res.data.errorMsg, undefined);
@@ -221,6 +287,21 @@ function App() {
     });
   }
 
+  // 滚动到指定消息，只有appendMessage显示的角色才占用列表中的位置
+  function scrollToMessage(chatMessages: any[], messageID: number) {
+    const index = chatMessages
+      .filter((item: any) => ['user', 'assistant', 'system'].includes(item.role))
+      .findIndex((item: any) => item.id === messageID)
+    if (index < 0) {
+      toast.fail('消息不在会话当前分支中')
+      return
+    }
+    setTimeout(() => {
+      const element = document.querySelectorAll('.MessageList .Message')[index]
+      element?.scrollIntoView({ block: 'center' })
+    }, 100)
+  }
+
   function handleNewChatClick() {
     // 清空已有消息
     resetList([])
@@ -231,7 +312,7 @@ function App() {
     chatContext.subject = '这是新的会话'
   }
 
-  function handleRefreshMenu(chatID: string) {
+  function handleRefreshMenu(chatID: string, messageID?: number) {
     getChatRecord().then((res) => {
       setChatData(res.data.data)
       let chatRecord = res.data.data.ChatRecord
@@ -247,15 +328,26 @@ function App() {
       } else {
         let index = chatRecord.findIndex((item: { ChatID: string }) => item.ChatID === chatID)
         if (index >= 0) {
-          handleMenuItemClick(chatRecord[index].ID, chatRecord[index].ChatID, chatRecord[index].Subject)
+          handleMenuItemClick(chatRecord[index].ID, chatRecord[index].ChatID, chatRecord[index].Subject, messageID)
+        } else if (messageID !== undefined) {
+          // 链接打开的会话可能不在会话列表中
+          handleMenuItemClick(0, chatID, '', messageID)
         }
       }
     })
  }
 
-  // 初始化
+  // 初始化，搜索结果、相似会话和复制分享返回的链接以?chatid=&messageid=打开指定会话
   useEffect(() => {
-    handleRefreshMenu('')
+    const params = new URLSearchParams(window.location.search)
+    const chatID = params.get('chatid') || ''
+    if (chatID === '') {
+      handleRefreshMenu('')
+      return
+    }
+    // 打开后去掉链接参数，刷新页面时仍开启新的会话
+    window.history.replaceState(null, '', window.location.pathname)
+    handleRefreshMenu(chatID, Number(params.get('messageid')) || 0)
   }, [])
 
   return (
@@ -263,7 +355,7 @@ function App() {
       <Layout>
         <ChatSidebar collapsed={collapsed} toggled={toggled} data={JSON.stringify(chatData)}
           onMenuItemClick={handleMenuItemClick} onNewChatClick={handleNewChatClick} 
-          refreshMenu={handleRefreshMenu}>
+          refreshMenu={handleRefreshMenu} onLoadMore={handleLoadMore}>
         </ChatSidebar>
         <Content style={{height: '100vh'}}>
           <Layout>
@@ -278,6 +370,7 @@ function App() {
                     {
                       icon: 'apps',
                       title: 'Applications',
+                      onClick: () => setAppsOpen(true),
                     },
                     {
                       icon: 'ellipsis-h',
@@ -303,6 +396,7 @@ function App() {
       <FloatButton icon={collapsed ? <RightOutlined /> : <LeftOutlined />}
         type="primary" shape='circle' tooltip={collapsed ? '展开会话记录' : '收起会话记录'}
         onClick={()=> setCollapsed(!collapsed)} style={{ top: 10, left: 10 }} />
+      <AppsModal open={appsOpen} onClose={() => setAppsOpen(false)} />
       {contextHolder}
     </div>
   )
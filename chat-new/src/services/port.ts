@@ -7,6 +7,140 @@ export const getUserInfo = () => {
     });
 };
 
+export const getProfile = () => {
+    return serviceAxios({
+        url: "/user/profile",
+        method: "post",
+    });
+};
+
+export const saveProfile = (profile: Object) => {
+    return serviceAxios({
+        url: "/user/saveprofile",
+        method: "post",
+        data: profile,
+    });
+};
+
+export const getPersonas = () => {
+    return serviceAxios({
+        url: "/persona/list",
+        method: "post",
+    });
+};
+
+export const getTemplates = (tag?: string) => {
+    return serviceAxios({
+        url: "/template/list",
+        method: "post",
+        data: {
+            tag: tag,
+        },
+    });
+};
+
+export const templateCompletion = (params: Object) => {
+    return serviceAxios({
+        url: "/chat/template",
+        method: "post",
+        data: params,
+    });
+};
+
+export const getApps = () => {
+    return serviceAxios({
+        url: "/app/list",
+        method: "post",
+    });
+};
+
+export const runApp = (key: string, values: Object) => {
+    return serviceAxios({
+        url: "/app/run/" + key,
+        method: "post",
+        data: {
+            values: values,
+        },
+    });
+};
+
+export const getFolders = () => {
+    return serviceAxios({
+        url: "/folder/list",
+        method: "post",
+    });
+};
+
+export const bulkChats = (chatIDs: string[], action: string, params?: Object) => {
+    return serviceAxios({
+        url: "/chat/bulk",
+        method: "post",
+        data: {
+            chatids: chatIDs,
+            action: action,
+            ...params,
+        },
+    });
+};
+
+export const searchChats = (query: string, limit?: number) => {
+    return serviceAxios({
+        url: "/chat/search",
+        method: "post",
+        data: {
+            query: query,
+            limit: limit,
+        },
+    });
+};
+
+export const similarChats = (params: { chatid?: string; query?: string; limit?: number }) => {
+    return serviceAxios({
+        url: "/chat/similar",
+        method: "post",
+        data: params,
+    });
+};
+
+export const createShare = (params: { chatid: string; messageid?: number; password?: string; days?: number }) => {
+    return serviceAxios({
+        url: "/share/create",
+        method: "post",
+        data: params,
+    });
+};
+
+export const getShares = (chatid?: string) => {
+    return serviceAxios({
+        url: "/share/list",
+        method: "post",
+        data: {
+            chatid: chatid,
+        },
+    });
+};
+
+export const revokeShare = (id: number) => {
+    return serviceAxios({
+        url: "/share/revoke",
+        method: "post",
+        data: {
+            id: id,
+        },
+    });
+};
+
+export const forkShare = (token: string, password?: string) => {
+    return serviceAxios({
+        url: "/share/fork",
+        method: "post",
+        data: {
+            token: token,
+            password: password,
+        },
+    });
+};
+
 export const login = (params: Object) => {
     return serviceAxios({
         url: "/user/auth",
@@ -23,10 +157,21 @@ export const completion = (chatContext: any) => {
     });
 };
 
-export const getChatRecord = async () => {
+export const continueCompletion = (chatID: any) => {
+    return serviceAxios({
+        url: "/chat/continue",
+        method: "post",
+        data: {
+            chatid: chatID,
+        },
+    });
+};
+
+export const getChatRecord = async (params?: Object) => {
     return serviceAxios({
         url: "/chat/userchatrecord",
         method: "post",
+        data: params,
     });
 };
 
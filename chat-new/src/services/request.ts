@@ -38,6 +38,9 @@ serviceAxios.interceptors.response.use(
                 case 403:
                     message = "您没有权限操作！";
                     break;
+                case 409:
+                    // 会话已在其他窗口更新，交给页面刷新会话
+                    return error.response
                 case 404:
                     message = `请求地址出错: ${error.response.config.url}`;
                     break;
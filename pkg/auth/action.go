@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model/user"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// ActionResetPassword 重置密码
+	ActionResetPassword = "reset_password"
+	// ActionVerifyEmail 验证邮箱
+	ActionVerifyEmail = "verify_email"
+)
+
+var errActionToken = errors.New("链接无效或已过期")
+
+// ActionClaims 邮件链接中使用的一次性操作令牌
+type ActionClaims struct {
+	UserID uint64
+	Action string
+	// Stamp 签发时用户状态的指纹，密码或邮箱状态变化后令牌随之失效，保证只能使用一次
+	Stamp string
+	jwt.StandardClaims
+}
+
+// EncodeAction 签发一次性操作令牌
+func EncodeAction(u *user.User, action string, ttl time.Duration) (string, error) {
+	claims := ActionClaims{
+		UserID: u.ID,
+		Action: action,
+		Stamp:  actionStamp(u, action),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			Issuer:    "chatgpt-web",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(key)
+}
+
+// DecodeAction 校验一次性操作令牌，返回令牌对应的用户
+func DecodeAction(tokenString string, action string) (*user.User, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ActionClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errActionToken
+	}
+
+	claims, ok := token.Claims.(*ActionClaims)
+	if !ok || claims.Action != action {
+		return nil, errActionToken
+	}
+
+	u, err := user.GetByID(claims.UserID)
+	if err != nil {
+		return nil, errActionToken
+	}
+	if !hmac.Equal([]byte(claims.Stamp), []byte(actionStamp(u, action))) {
+		return nil, errActionToken
+	}
+	return u, nil
+}
+
+// actionStamp 计算用户状态指纹
+// 重置密码绑定当前密码哈希，验证邮箱绑定当前邮箱及验证状态
+func actionStamp(u *user.User, action string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(action))
+	switch action {
+	case ActionResetPassword:
+		mac.Write([]byte(u.Password))
+	case ActionVerifyEmail:
+		mac.Write([]byte(u.Email))
+		mac.Write([]byte(strconv.FormatBool(u.EmailVerified)))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
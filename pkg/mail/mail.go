@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/869413421/chatgpt-web/config"
+)
+
+// Enabled 是否配置了邮件服务器
+func Enabled() bool {
+	return config.LoadConfig().SMTPHost != ""
+}
+
+// Send 发送纯文本邮件
+// 未配置smtp_user时不进行认证，可直接对接本地的MailHog等测试邮件服务
+func Send(to string, subject string, body string) error {
+	cnf := config.LoadConfig()
+	if cnf.SMTPHost == "" {
+		return errors.New("未配置邮件服务器")
+	}
+
+	from := cnf.SMTPFrom
+	if from == "" {
+		from = cnf.SMTPUser
+	}
+	if from == "" {
+		return errors.New("未配置发件人地址")
+	}
+
+	var auth smtp.Auth
+	if cnf.SMTPUser != "" {
+		auth = smtp.PlainAuth("", cnf.SMTPUser, cnf.SMTPPassword, cnf.SMTPHost)
+	}
+
+	addr := net.JoinHostPort(cnf.SMTPHost, strconv.Itoa(cnf.SMTPPort))
+	return smtp.SendMail(addr, auth, from, []string{to}, buildMessage(from, to, subject, body))
+}
+
+// buildMessage 组装邮件内容，正文使用base64编码避免中文乱码
+func buildMessage(from string, to string, subject string, body string) []byte {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("From: %s\r\n", from))
+	builder.WriteString(fmt.Sprintf("To: %s\r\n", to))
+	builder.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject)))
+	builder.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("Content-Transfer-Encoding: base64\r\n")
+	builder.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	// 按RFC 2045要求每行不超过76个字符
+	for len(encoded) > 76 {
+		builder.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	builder.WriteString(encoded + "\r\n")
+	return []byte(builder.String())
+}
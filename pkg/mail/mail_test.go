@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/869413421/chatgpt-web/config"
+)
+
+// sinkMail 本地SMTP接收端收到的邮件
+type sinkMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// startSink 启动只接收邮件的本地SMTP服务，返回监听端口和收到的邮件
+func startSink(t *testing.T) (int, <-chan sinkMail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan sinkMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) {
+			conn.Write([]byte(line + "\r\n"))
+		}
+
+		var mail sinkMail
+		reply("220 sink ready")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 sink")
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				mail.From = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<>")
+				reply("250 ok")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				mail.To = append(mail.To, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+				reply("250 ok")
+			case command == "DATA":
+				reply("354 end with .")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				mail.Data = data.String()
+				received <- mail
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSendToLocalSink(t *testing.T) {
+	port, received := startSink(t)
+	// 没有配置文件时加载配置要求设置接口密钥
+	t.Setenv("APIKEY", "test")
+	cnf := config.LoadConfig()
+	cnf.SMTPHost = "127.0.0.1"
+	cnf.SMTPPort = port
+	cnf.SMTPUser = ""
+	cnf.SMTPFrom = "noreply@example.com"
+
+	if !Enabled() {
+		t.Fatal("Enabled() = false with smtp_host configured")
+	}
+	body := "请打开以下链接重置密码：\nhttp://example.com/resetpassword?token=abc"
+	if err := Send("user@example.com", "重置密码", body); err != nil {
+		t.Fatal(err)
+	}
+
+	mail := <-received
+	if mail.From != "noreply@example.com" || len(mail.To) != 1 || mail.To[0] != "user@example.com" {
+		t.Errorf("envelope = from %q to %v", mail.From, mail.To)
+	}
+	header, encoded, found := strings.Cut(mail.Data, "\r\n\r\n")
+	if !found {
+		t.Fatalf("message has no body: %q", mail.Data)
+	}
+	if !strings.Contains(header, "Subject: =?UTF-8?b?") || !strings.Contains(header, "Content-Transfer-Encoding: base64") {
+		t.Errorf("unexpected header: %q", header)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\r\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != body {
+		t.Errorf("body = %q, want %q", decoded, body)
+	}
+}
+
+func TestBuildMessageWrapsLines(t *testing.T) {
+	message := string(buildMessage("a@example.com", "b@example.com", "subject", strings.Repeat("长", 200)))
+	_, encoded, _ := strings.Cut(message, "\r\n\r\n")
+	for _, line := range strings.Split(strings.TrimRight(encoded, "\r\n"), "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("body line has %d characters, want at most 76", len(line))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// 表单字段类型
+const (
+	FieldText     = "text"
+	FieldTextarea = "textarea"
+	FieldSelect   = "select"
+)
+
+// Field 应用的表单字段，用户填写后替换提示词模板中的{{Name}}
+type Field struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// App 管理员发布的应用，系统提示词、模型及参数固定，用户只能填写表单
+type App struct {
+	model.BaseModel
+	// Key 应用的唯一标识，用于访问地址
+	Key          string `gorm:"column:app_key;type:varchar(64);not null;unique" valid:"app_key"`
+	Name         string `gorm:"column:name;type:varchar(64);not null" valid:"name"`
+	Description  string `gorm:"column:description;type:varchar(255);not null;default:''" valid:"description"`
+	SystemPrompt string `gorm:"column:system_prompt;type:text;not null" valid:"system_prompt"`
+	// Template 用户消息模板，为空时按字段顺序拼接填写的内容
+	Template string  `gorm:"column:template;type:text" valid:"template"`
+	Fields   []Field `gorm:"column:fields;type:text;serializer:json" valid:"fields"`
+	// Tools 允许模型调用的工具
+	Tools []string `gorm:"column:tools;type:varchar(255);serializer:json" valid:"tools"`
+	// GroupIDs 可以使用的用户组，为空时所有用户可用
+	GroupIDs []uint64 `gorm:"column:group_ids;type:varchar(255);serializer:json" valid:"group_ids"`
+	Enabled  bool     `gorm:"column:enabled;type:bool;not null;default:false" valid:"enabled"`
+	model.Params
+}
+
+// AllowGroup 指定用户组的用户是否可以使用该应用
+func (a *App) AllowGroup(groupId uint64) bool {
+	if len(a.GroupIDs) == 0 {
+		return true
+	}
+	for _, id := range a.GroupIDs {
+		if id == groupId {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckValues 校验用户填写的表单，去掉未定义的字段
+func (a *App) CheckValues(values map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(a.Fields))
+	for _, field := range a.Fields {
+		value := strings.TrimSpace(values[field.Name])
+		if value == "" && field.Required {
+			return nil, fmt.Errorf("请填写%s", field.Label)
+		}
+		if value != "" && field.Type == FieldSelect && !contains(field.Options, value) {
+			return nil, fmt.Errorf("%s的取值不正确", field.Label)
+		}
+		result[field.Name] = value
+	}
+	return result, nil
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Get 根据ID获取应用
+func Get(id uint64) (app *App, err error) {
+	app = &App{}
+	err = model.DB.Where("id = ?", id).First(app).Error
+	return
+}
+
+// GetByKey 根据标识获取应用
+func GetByKey(key string) (app *App, err error) {
+	app = &App{}
+	err = model.DB.Where("app_key = ?", key).First(app).Error
+	return
+}
+
+// List 获取应用，enabledOnly为true时只返回已启用的应用
+func List(enabledOnly bool) (apps []*App, err error) {
+	query := model.DB.Order("id ASC")
+	if enabledOnly {
+		query = query.Where("enabled = ?", true)
+	}
+	err = query.Find(&apps).Error
+	return
+}
+
+// Save 创建或更新应用，ID为0时创建
+func Save(app *App) error {
+	return model.DB.Save(app).Error
+}
+
+// Delete 删除应用
+func Delete(id uint64) error {
+	return model.DB.Delete(&App{}, id).Error
+}
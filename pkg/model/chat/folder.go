@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Folder 用户的会话文件夹
+type Folder struct {
+	model.BaseModel
+	UserID uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	Name   string `gorm:"column:name;type:varchar(64);not null" valid:"name"`
+}
+
+// SelectFolders 查询用户的全部文件夹
+func SelectFolders(userId uint64) (folders []*Folder, err error) {
+	err = model.DB.Where("user_id = ?", userId).Order("id ASC").Find(&folders).Error
+	return
+}
+
+// SelectFolder 查询用户的文件夹
+func SelectFolder(userId uint64, id uint64) (folder *Folder, err error) {
+	folder = &Folder{}
+	err = model.DB.Where("id = ? AND user_id = ?", id, userId).First(folder).Error
+	return
+}
+
+// SaveFolder 创建或重命名文件夹，id为0时创建
+func SaveFolder(userId uint64, id uint64, name string) (folder *Folder, err error) {
+	folder = &Folder{UserID: userId}
+	if id != 0 {
+		if folder, err = SelectFolder(userId, id); err != nil {
+			return
+		}
+	}
+	folder.Name = name
+	err = model.DB.Save(folder).Error
+	return
+}
+
+// DeleteFolder 删除文件夹，其中的会话移出文件夹
+func DeleteFolder(userId uint64, id uint64) error {
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Record{}).Where("user_id = ? AND folder_id = ?", userId, id).UpdateColumn("folder_id", 0).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("id = ? AND user_id = ?", id, userId).Delete(&Folder{}).Error
+	})
+}
@@ -0,0 +1,23 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// setupDB 每个测试使用独立的内存数据库
+func setupDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&Record{}, &Message{}, &Folder{}, &Tag{}, &Share{}); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+}
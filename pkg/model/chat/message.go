@@ -0,0 +1,272 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// ErrMessageNotFound 消息不存在或不属于该会话
+var ErrMessageNotFound = errors.New("消息不存在")
+
+// Message 聊天消息，通过ParentID串联成对话
+type Message struct {
+	model.BaseModel
+	RecordID uint64 `gorm:"column:record_id;type:bigint(20);not null;index" valid:"record_id"`
+	ParentID uint64 `gorm:"column:parent_id;type:bigint(20);not null;default:0;index" valid:"parent_id"` // 上一条消息，0表示第一条
+	Role     string `gorm:"column:role;type:varchar(32);not null" valid:"role"`
+	Content  string `gorm:"column:content;type:text;not null" valid:"content"`
+	Model    string `gorm:"column:model;type:varchar(255);not null;default:''" valid:"model"`
+	// Tokens 消息自身的token数，用户消息为估算值，回复为上游返回的completion_tokens
+	Tokens int `gorm:"column:tokens;not null;default:0" valid:"tokens"`
+	// PromptTokens 生成该回复时发送的提示词token数，仅回复消息有值
+	PromptTokens int `gorm:"column:prompt_tokens;not null;default:0" valid:"prompt_tokens"`
+	// Incomplete 回复因长度限制被截断，可以继续输出
+	Incomplete bool `gorm:"column:incomplete;not null;default:false" valid:"incomplete"`
+}
+
+// SelectMessages 查询会话的全部消息，按创建顺序排列
+func SelectMessages(recordId uint64) (messages []*Message, err error) {
+	err = model.DB.Where("record_id = ?", recordId).Order("id ASC").Find(&messages).Error
+	return
+}
+
+// SelectMessagesByIds 按主键查询多条消息
+func SelectMessagesByIds(ids []uint64) (messages []*Message, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	err = model.DB.Where("id IN ?", ids).Find(&messages).Error
+	return
+}
+
+// SelectPath 查询从第一条消息到当前最后一条消息的对话路径
+func SelectPath(record *Record) (path []*Message, err error) {
+	messages, err := SelectMessages(record.ID)
+	if err != nil {
+		return
+	}
+	path = buildPath(messages, record.LeafID)
+	return
+}
+
+// SelectPathTo 查询从第一条消息到指定消息的对话路径，messageId为0时返回空路径
+func SelectPathTo(record *Record, messageId uint64) (path []*Message, err error) {
+	messages, err := SelectMessages(record.ID)
+	if err != nil {
+		return
+	}
+	path = buildPath(messages, messageId)
+	return
+}
+
+// SelectTree 查询当前对话路径，以及每条消息的全部分支
+// children以父消息ID为键，值为按创建顺序排列的子消息ID，同一父消息下的消息互为兄弟分支
+func SelectTree(record *Record) (path []*Message, children map[uint64][]uint64, err error) {
+	messages, err := SelectMessages(record.ID)
+	if err != nil {
+		return
+	}
+	path = buildPath(messages, record.LeafID)
+	children = buildChildren(messages)
+	return
+}
+
+// SelectMessage 查询会话中的指定消息
+func SelectMessage(record *Record, messageId uint64) (message *Message, err error) {
+	message = &Message{}
+	err = model.DB.Where("id = ? AND record_id = ?", messageId, record.ID).First(message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrMessageNotFound
+	}
+	return
+}
+
+// SelectSiblings 查询与指定消息同一父消息的全部分支，按创建顺序排列
+func SelectSiblings(record *Record, messageId uint64) (siblings []*Message, err error) {
+	message, err := SelectMessage(record, messageId)
+	if err != nil {
+		return
+	}
+	err = model.DB.Where("record_id = ? AND parent_id = ?", record.ID, message.ParentID).Order("id ASC").Find(&siblings).Error
+	return
+}
+
+// AppendMessages 在会话最后一条消息后依次追加消息，并更新会话的最后一条消息
+func AppendMessages(record *Record, messages ...*Message) error {
+	return AppendMessagesTo(record, record.LeafID, messages...)
+}
+
+// AppendMessagesTo 在指定消息后依次追加消息，并把会话切换到新的分支
+// parentId不是最后一条消息时即从该处产生新的分支，原有分支保持不变
+// 以读取时的版本号做比较交换，期间会话已被其他请求修改时回滚并返回ErrConflict
+func AppendMessagesTo(record *Record, parentId uint64, messages ...*Message) error {
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		for _, message := range messages {
+			message.RecordID = record.ID
+			message.ParentID = parentId
+			if err := tx.Create(message).Error; err != nil {
+				return err
+			}
+			parentId = message.ID
+		}
+		return updateLeaf(tx, record, parentId)
+	})
+}
+
+// UpdateContinued 保存续写后的回复内容，并增加会话版本号
+func UpdateContinued(record *Record, message *Message) error {
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(message).Select("content", "tokens", "prompt_tokens", "incomplete", "updated_at").Updates(message).Error
+		if err != nil {
+			return err
+		}
+		return updateLeaf(tx, record, record.LeafID)
+	})
+}
+
+// SwitchBranch 切换到指定消息所在的分支
+// 指定消息之后沿最近创建的子消息走到底，作为会话新的最后一条消息
+func SwitchBranch(record *Record, messageId uint64) error {
+	messages, err := SelectMessages(record.ID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, message := range messages {
+		if message.ID == messageId {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrMessageNotFound
+	}
+
+	children := buildChildren(messages)
+	leafId := messageId
+	for len(children[leafId]) > 0 {
+		next := children[leafId]
+		leafId = next[len(next)-1]
+	}
+	return updateLeaf(model.DB, record, leafId)
+}
+
+// updateLeaf 以比较交换的方式更新会话的最后一条消息，并增加版本号
+func updateLeaf(tx *gorm.DB, record *Record, leafId uint64) error {
+	updatedAt := time.Now()
+	result := tx.Model(&Record{}).
+		Where("id = ? AND version = ?", record.ID, record.Version).
+		UpdateColumns(map[string]interface{}{
+			"leaf_id":    leafId,
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": updatedAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+
+	record.LeafID = leafId
+	record.Version++
+	record.UpdatedAt = updatedAt
+	return nil
+}
+
+// buildChildren 按父消息ID归类子消息ID，保持创建顺序
+func buildChildren(messages []*Message) map[uint64][]uint64 {
+	children := make(map[uint64][]uint64)
+	for _, message := range messages {
+		children[message.ParentID] = append(children[message.ParentID], message.ID)
+	}
+	return children
+}
+
+// buildPath 从leafId沿ParentID回溯，返回正序的对话路径
+func buildPath(messages []*Message, leafId uint64) []*Message {
+	byId := make(map[uint64]*Message, len(messages))
+	for _, message := range messages {
+		byId[message.ID] = message
+	}
+
+	var path []*Message
+	for id := leafId; id != 0; {
+		message, ok := byId[id]
+		if !ok {
+			break
+		}
+		path = append(path, message)
+		id = message.ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// legacyMessage 旧版本保存在Record.Messages中的消息格式
+type legacyMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ErrMigrateFailed 旧版本的消息无法解析，没有迁移到消息表
+var ErrMigrateFailed = errors.New("该会话旧版本的消息无法迁移，请联系管理员处理")
+
+// MigrateFailed 启动时迁移旧版本消息失败，Messages中仍保留原始数据
+func (r *Record) MigrateFailed() bool {
+	return r.Messages != ""
+}
+
+// MigrateMessages 将旧版本保存在Record.Messages中的JSON消息拆分到消息表，迁移后清空原字段
+// 每个会话单独使用事务，重复执行不会重复迁移；无法解析的会话记录日志后跳过，原字段保留以便人工处理
+func MigrateMessages() (migrated int, err error) {
+	var records []*Record
+	err = model.DB.Where("messages IS NOT NULL AND messages <> ''").Find(&records).Error
+	if err != nil {
+		return
+	}
+
+	for _, record := range records {
+		var legacy []legacyMessage
+		if err := json.Unmarshal([]byte(record.Messages), &legacy); err != nil {
+			logger.Warning("skip migrating chat messages of record", record.ID, "chat_id", record.ChatID, "error:", err)
+			continue
+		}
+
+		err = model.DB.Transaction(func(tx *gorm.DB) error {
+			parentId := record.LeafID
+			for _, item := range legacy {
+				message := &Message{
+					RecordID: record.ID,
+					ParentID: parentId,
+					Role:     item.Role,
+					Content:  item.Content,
+				}
+				// 保留原有的时间顺序
+				message.CreatedAt = record.CreatedAt
+				message.UpdatedAt = record.UpdatedAt
+				if err := tx.Create(message).Error; err != nil {
+					return err
+				}
+				parentId = message.ID
+			}
+			return tx.Model(record).UpdateColumns(map[string]interface{}{
+				"leaf_id":  parentId,
+				"messages": "",
+			}).Error
+		})
+		if err != nil {
+			return
+		}
+		migrated++
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+func TestMigrateMessagesSkipsInvalidRecord(t *testing.T) {
+	setupDB(t)
+	records := []*Record{
+		{UserID: 1, ChatID: "bad", Subject: "bad", Messages: "{not json"},
+		{UserID: 1, ChatID: "good", Subject: "good", Messages: `[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]`},
+	}
+	for _, record := range records {
+		if err := model.DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrated, err := MigrateMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 {
+		t.Errorf("migrated = %d, want 1", migrated)
+	}
+
+	good, err := SelectRecordByChatId("good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := SelectPath(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 2 || path[0].Content != "hi" || path[1].Content != "hello" || good.MigrateFailed() {
+		t.Errorf("good record path = %d messages, legacy field %q", len(path), good.Messages)
+	}
+
+	// 无法解析的会话保留原字段，不影响后续会话
+	bad, err := SelectRecordByChatId("bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad.Messages != "{not json" || bad.LeafID != 0 || !bad.MigrateFailed() {
+		t.Errorf("bad record = {Messages: %q, LeafID: %d}", bad.Messages, bad.LeafID)
+	}
+
+	// 再次执行不会重复迁移
+	if migrated, err = MigrateMessages(); err != nil || migrated != 0 {
+		t.Errorf("second run migrated = %d, err = %v", migrated, err)
+	}
+}
@@ -1,19 +1,48 @@
 package chat
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/869413421/chatgpt-web/pkg/model"
 )
 
 type Record struct {
 	model.BaseModel
-	UserID   uint64 `gorm:"column:user_id;type:bigint(20);not null" valid:"user_id"`
-	ChatID   string `gorm:"column:chat_id;type:varchar(255);not null;unique" valid:"chat_id"`
-	Subject  string `gorm:"column:subject;type:varchar(255);not null" valid:"subject"`
+	UserID  uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	ChatID  string `gorm:"column:chat_id;type:varchar(255);not null;unique" valid:"chat_id"`
+	Subject string `gorm:"column:subject;type:varchar(255);not null" valid:"subject"`
+	// Messages 旧版本以JSON保存的全部消息，启动时迁移到消息表后清空，无法解析时保留
 	Messages string `gorm:"column:messages" valid:"messages"`
+	// LeafID 会话最后一条消息的ID
+	LeafID uint64 `gorm:"column:leaf_id;type:bigint(20);not null;default:0" valid:"leaf_id"`
+	// Version 乐观锁版本号，每次追加消息时加一
+	Version uint64 `gorm:"column:version;type:bigint(20);not null;default:0" valid:"version"`
+	// Summary 较早对话的摘要，超出上下文窗口时代替这些对话发送
+	Summary string `gorm:"column:summary;type:text" valid:"summary"`
+	// SummaryUntilID 摘要涵盖到的最后一条消息
+	SummaryUntilID uint64 `gorm:"column:summary_until_id;type:bigint(20);not null;default:0" valid:"summary_until_id"`
+	// FolderID 所在文件夹，0表示未分类
+	FolderID uint64 `gorm:"column:folder_id;type:bigint(20);not null;default:0;index" valid:"folder_id"`
+	// Pinned 置顶的会话排在列表最前
+	Pinned bool `gorm:"column:pinned;type:bool;not null;default:false" valid:"pinned"`
+	// Archived 归档的会话默认不在列表中显示
+	Archived bool `gorm:"column:archived;type:bool;not null;default:false" valid:"archived"`
+	// PersonaID 创建会话时选择的角色，为0时使用用户或全局的系统提示词
+	PersonaID uint64 `gorm:"column:persona_id;type:bigint(20);not null;default:0" valid:"persona_id"`
+	// 会话使用的模型及参数，优先于角色、用户和全局的设置
+	model.Params
 }
 
+// ErrConflict 会话在读取后已被其他请求修改
+var ErrConflict = errors.New("会话已在其他窗口更新，请刷新后重试")
+
 // SelectRecordByChatId 根据chatId查询数据
 func SelectRecordByChatId(chatId string) (record *Record, err error) {
 	record = &Record{}
@@ -27,6 +56,159 @@ func SelectRecordByUserId(userId uint64) (records []*Record, err error) {
 	return
 }
 
+// RecordFilter 会话列表的筛选条件，零值表示不筛选
+type RecordFilter struct {
+	// FolderID 为nil时不限文件夹，指向0时只查询未分类的会话
+	FolderID *uint64
+	Tag      string
+	Pinned   *bool
+	// Archived 为true时只查询归档的会话，否则只查询未归档的会话
+	Archived bool
+	// Keyword 按主题模糊查询
+	Keyword string
+}
+
+// 会话列表的排序方式
+const (
+	// SortUpdated 按最后活动时间倒序
+	SortUpdated = "updated"
+	// SortCreated 按创建时间倒序
+	SortCreated = "created"
+)
+
+// ErrInvalidCursor 分页游标无法解析
+var ErrInvalidCursor = errors.New("分页游标不正确")
+
+// DefaultRecordPageSize 未指定每页数量时返回的会话数
+const DefaultRecordPageSize = 50
+
+// RecordPage 会话列表的分页参数，Limit为0时每页DefaultRecordPageSize个
+type RecordPage struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+// SelectRecords 按条件分页查询用户的会话，置顶的在前，其余按排序方式倒序排列
+// 按置顶、时间、ID组成的游标分页，还有下一页时返回下一页的游标
+func SelectRecords(userId uint64, filter RecordFilter, page RecordPage) (records []*Record, next string, err error) {
+	query := model.DB.Where("user_id = ? AND archived = ?", userId, filter.Archived)
+	if filter.FolderID != nil {
+		query = query.Where("folder_id = ?", *filter.FolderID)
+	}
+	if filter.Pinned != nil {
+		query = query.Where("pinned = ?", *filter.Pinned)
+	}
+	if filter.Tag != "" {
+		query = query.Where("id IN (?)", model.DB.Model(&Tag{}).Select("record_id").Where("user_id = ? AND name = ?", userId, filter.Tag))
+	}
+	if filter.Keyword != "" {
+		query = query.Where("subject LIKE ?", "%"+filter.Keyword+"%")
+	}
+
+	column := "updated_at"
+	if page.Sort == SortCreated {
+		column = "created_at"
+	}
+	if page.Cursor != "" {
+		pinned, at, id, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("pinned < ? OR (pinned = ? AND ("+column+" < ? OR ("+column+" = ? AND id < ?)))", pinned, pinned, at, at, id)
+	}
+	if page.Limit <= 0 {
+		page.Limit = DefaultRecordPageSize
+	}
+	// 多查一条判断是否还有下一页
+	query = query.Order("pinned DESC").Order(column + " DESC").Order("id DESC").Limit(page.Limit + 1)
+	if err = query.Find(&records).Error; err != nil {
+		return
+	}
+
+	if len(records) > page.Limit {
+		records = records[:page.Limit]
+		last := records[len(records)-1]
+		at := last.UpdatedAt
+		if column == "created_at" {
+			at = last.CreatedAt
+		}
+		next = encodeCursor(last.Pinned, at, last.ID)
+	}
+	return
+}
+
+// encodeCursor 将最后一条记录的排序字段编码为游标
+func encodeCursor(pinned bool, at time.Time, id uint64) string {
+	flag := 0
+	if pinned {
+		flag = 1
+	}
+	raw := fmt.Sprintf("%d|%d|%d", flag, at.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor 解析游标
+func decodeCursor(cursor string) (pinned bool, at time.Time, id uint64, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return false, at, 0, ErrInvalidCursor
+	}
+	var flag int
+	var nanos int64
+	if _, err = fmt.Sscanf(string(raw), "%d|%d|%d", &flag, &nanos, &id); err != nil {
+		return false, at, 0, ErrInvalidCursor
+	}
+	return flag == 1, time.Unix(0, nanos), id, nil
+}
+
+// SelectRecordsByChatIds 查询用户的多个会话，不属于该用户的会话忽略
+func SelectRecordsByChatIds(userId uint64, chatIds []string) (records []*Record, err error) {
+	if len(chatIds) == 0 {
+		return
+	}
+	err = model.DB.Where("user_id = ? AND chat_id IN ?", userId, chatIds).Find(&records).Error
+	return
+}
+
+// SelectRecordsByIds 按主键查询用户的多个会话，不属于该用户的会话忽略
+func SelectRecordsByIds(userId uint64, ids []uint64) (records []*Record, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	err = model.DB.Where("user_id = ? AND id IN ?", userId, ids).Find(&records).Error
+	return
+}
+
+// UpdateRecords 批量修改会话的文件夹、置顶、归档等整理状态，不改变会话的更新时间和版本
+func UpdateRecords(records []*Record, columns map[string]interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return model.DB.Model(&Record{}).Where("id IN ?", recordIds(records)).UpdateColumns(columns).Error
+}
+
+// DeleteRecords 批量删除会话及其消息和标签
+func DeleteRecords(records []*Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	ids := recordIds(records)
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("record_id IN ?", ids).Delete(&Message{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&Tag{}).Error; err != nil {
+			return err
+		}
+		// 会话删除后其分享链接一并失效
+		if err := tx.Where("record_id IN ?", ids).Delete(&Share{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&Record{}).Error
+	})
+}
+
 // UpdateRecord 更新聊天记录，如果不存在则创建
 func UpdateRecord(userId uint64, chatId string, subject string, messages string) (record *Record, err error) {
 	record = &Record{}
@@ -43,7 +225,8 @@ func UpdateRecord(userId uint64, chatId string, subject string, messages string)
 		if messages != "" {
 			record.Messages = messages
 		}
-		err = model.DB.Updates(record).Error
+		// 只更新主题和旧消息字段，避免用读取时的leaf_id和version覆盖并发追加的消息
+		err = model.DB.Model(record).Select("subject", "messages", "updated_at").Updates(record).Error
 	} else {
 		record.UserID = userId
 		record.ChatID = chatId
@@ -54,6 +237,54 @@ func UpdateRecord(userId uint64, chatId string, subject string, messages string)
 	return
 }
 
+// ReplaceSubject 仅当主题仍为oldSubject时修改为subject，避免覆盖期间用户的重命名，返回是否已修改
+func ReplaceSubject(record *Record, oldSubject string, subject string) (bool, error) {
+	updatedAt := time.Now()
+	result := model.DB.Model(&Record{}).Where("id = ? AND subject = ?", record.ID, oldSubject).
+		UpdateColumns(map[string]interface{}{"subject": subject, "updated_at": updatedAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	record.Subject = subject
+	record.UpdatedAt = updatedAt
+	return true, nil
+}
+
+// CreateRecord 创建聊天记录，如果已被其他请求创建则返回ErrConflict
+// 由chat_id的唯一索引判断，并发创建同一会话时只有一个请求成功
+func CreateRecord(record *Record) error {
+	result := model.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}},
+		DoNothing: true,
+	}).Create(record)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// UpdateParams 修改会话使用的模型及参数
+func UpdateParams(record *Record, params model.Params) error {
+	record.Params = params
+	return model.DB.Model(record).Select("model", "temperature", "top_p", "presence_penalty", "frequency_penalty", "max_tokens").Updates(record).Error
+}
+
+// UpdateSummary 更新会话摘要，摘要由历史消息生成，不改变会话版本
+func UpdateSummary(record *Record, summary string, untilId uint64) error {
+	record.Summary = summary
+	record.SummaryUntilID = untilId
+	return model.DB.Model(record).UpdateColumns(map[string]interface{}{
+		"summary":          summary,
+		"summary_until_id": untilId,
+	}).Error
+}
+
 // DeleteRecordByChatId 删除聊天记录
 func DeleteRecordByChatId(userId uint64, chatId string) (record *Record, err error) {
 	record = &Record{}
@@ -63,7 +294,82 @@ func DeleteRecordByChatId(userId uint64, chatId string) (record *Record, err err
 			err = fmt.Errorf("该聊天记录不属于当前用户")
 			return
 		}
-		err = model.DB.Delete(record).Error
+		err = model.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("record_id = ?", record.ID).Delete(&Message{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("record_id = ?", record.ID).Delete(&Tag{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("record_id = ?", record.ID).Delete(&Share{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(record).Error
+		})
 	}
 	return
 }
+
+// snippetLength 会话列表中最后一条消息摘录的字数
+const snippetLength = 80
+
+// Excerpt 合并空白后截取消息开头的snippetLength个字
+func Excerpt(content string) string {
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	if len(runes) > snippetLength {
+		runes = runes[:snippetLength]
+	}
+	return string(runes)
+}
+
+// RecordStats 会话列表中展示的会话概况
+type RecordStats struct {
+	MessageCount int64
+	// Snippet 当前分支最后一条消息的摘录
+	Snippet string
+	// Model 最后一次回复使用的模型，还没有回复时为会话设置的模型
+	Model string
+}
+
+// SelectRecordStats 查询会话的消息数及最后一条消息，返回会话ID到概况的映射
+func SelectRecordStats(records []*Record) (map[uint64]*RecordStats, error) {
+	result := make(map[uint64]*RecordStats, len(records))
+	if len(records) == 0 {
+		return result, nil
+	}
+	var counts []struct {
+		RecordID uint64
+		Count    int64
+	}
+	err := model.DB.Model(&Message{}).Select("record_id, COUNT(*) AS count").
+		Where("record_id IN ?", recordIds(records)).Group("record_id").Scan(&counts).Error
+	if err != nil {
+		return result, err
+	}
+	leafIds := make([]uint64, 0, len(records))
+	for _, record := range records {
+		result[record.ID] = &RecordStats{Model: record.Model}
+		if record.LeafID != 0 {
+			leafIds = append(leafIds, record.LeafID)
+		}
+	}
+	for _, item := range counts {
+		result[item.RecordID].MessageCount = item.Count
+	}
+
+	var leaves []*Message
+	if len(leafIds) > 0 {
+		err = model.DB.Where("id IN ?", leafIds).Find(&leaves).Error
+	}
+	for _, leaf := range leaves {
+		stats, ok := result[leaf.RecordID]
+		if !ok {
+			continue
+		}
+		stats.Snippet = Excerpt(leaf.Content)
+		if leaf.Model != "" {
+			stats.Model = leaf.Model
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+func TestCursor(t *testing.T) {
+	at := time.Date(2026, 10, 19, 8, 30, 0, 123456789, time.UTC)
+	tests := []struct {
+		pinned bool
+		at     time.Time
+		id     uint64
+	}{
+		{false, at, 1},
+		{true, at, 18446744073709551615},
+		{false, time.Unix(0, 0), 0},
+	}
+	for _, tt := range tests {
+		cursor := encodeCursor(tt.pinned, tt.at, tt.id)
+		pinned, decodedAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q) error: %v", cursor, err)
+		}
+		if pinned != tt.pinned || !decodedAt.Equal(tt.at) || id != tt.id {
+			t.Errorf("decodeCursor(encodeCursor(%v, %v, %d)) = %v, %v, %d", tt.pinned, tt.at, tt.id, pinned, decodedAt, id)
+		}
+	}
+
+	invalid := []string{"not base64!", base64.RawURLEncoding.EncodeToString([]byte("garbage")), ""}
+	for _, cursor := range invalid {
+		if _, _, _, err := decodeCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestSelectRecordsPaging(t *testing.T) {
+	setupDB(t)
+	start := time.Now().Add(-time.Hour)
+	total := DefaultRecordPageSize + 5
+	for i := 0; i < total; i++ {
+		record := &Record{UserID: 1, ChatID: fmt.Sprintf("chat-%d", i), Subject: "subject", Pinned: i == 0}
+		if err := model.DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+		// 部分会话的更新时间相同，按ID区分先后
+		updated := start.Add(time.Duration(i/2) * time.Minute)
+		if err := model.DB.Model(record).UpdateColumn("updated_at", updated).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var order []string
+	cursor := ""
+	pages := 0
+	for {
+		records, next, err := SelectRecords(1, RecordFilter{}, RecordPage{Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) > DefaultRecordPageSize {
+			t.Fatalf("page size = %d, want at most %d", len(records), DefaultRecordPageSize)
+		}
+		for _, record := range records {
+			if seen[record.ChatID] {
+				t.Fatalf("record %s returned twice", record.ChatID)
+			}
+			seen[record.ChatID] = true
+			order = append(order, record.ChatID)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if pages != 2 || len(seen) != total {
+		t.Errorf("pages = %d, records = %d, want 2 pages and %d records", pages, len(seen), total)
+	}
+	if order[0] != "chat-0" {
+		t.Errorf("first record = %s, want pinned chat-0", order[0])
+	}
+	if order[1] != fmt.Sprintf("chat-%d", total-1) {
+		t.Errorf("second record = %s, want latest chat-%d", order[1], total-1)
+	}
+}
+
+func TestCreateRecordConflict(t *testing.T) {
+	setupDB(t)
+	if err := CreateRecord(&Record{UserID: 1, ChatID: "same", Subject: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	second := &Record{UserID: 2, ChatID: "same", Subject: "second"}
+	if err := CreateRecord(second); err != ErrConflict {
+		t.Fatalf("CreateRecord() duplicate error = %v, want ErrConflict", err)
+	}
+	record, err := SelectRecordByChatId("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.UserID != 1 || record.Subject != "first" {
+		t.Errorf("record = user %d %q, want the first one kept", record.UserID, record.Subject)
+	}
+}
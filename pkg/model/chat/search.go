@@ -0,0 +1,338 @@
+package chat
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// ErrEmptyQuery 搜索关键词为空
+var ErrEmptyQuery = errors.New("请输入搜索关键词")
+
+// searchSnippetLength 搜索结果中摘录的字数
+const searchSnippetLength = 120
+
+// SearchResult 搜索结果，MessageID为0表示匹配的是会话主题
+// Subject和Snippet已做HTML转义，匹配的关键词以<mark>标出
+type SearchResult struct {
+	ChatID    string
+	Subject   string
+	MessageID uint64
+	Role      string
+	Snippet   string
+	CreatedAt time.Time
+}
+
+// searchHit 各搜索实现返回的匹配消息
+type searchHit struct {
+	ID        uint64
+	ChatID    string
+	Subject   string
+	Role      string
+	Content   string
+	CreatedAt time.Time
+}
+
+// searcher 消息全文搜索的实现，按数据库使用各自的全文索引
+type searcher interface {
+	// setup 创建全文索引，启动时调用，需要可以重复执行
+	setup(db *gorm.DB) error
+	// search 搜索用户的消息，按相关度排序
+	search(db *gorm.DB, userId uint64, terms []string, limit int) ([]*searchHit, error)
+}
+
+// activeSearcher 创建好索引的搜索实现，未创建或创建失败时为nil，使用模糊匹配
+var activeSearcher searcher
+
+// indexSearcher 根据数据库类型选择使用全文索引的搜索实现
+func indexSearcher(db *gorm.DB) searcher {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return sqliteSearcher{}
+	case "mysql":
+		return mysqlSearcher{}
+	case "postgres":
+		return postgresSearcher{}
+	default:
+		return fallbackSearcher(db)
+	}
+}
+
+// fallbackSearcher 没有全文索引时的模糊匹配，PostgreSQL的LIKE区分大小写，使用ILIKE
+func fallbackSearcher(db *gorm.DB) searcher {
+	if db.Dialector.Name() == "postgres" {
+		return likeSearcher{operator: "ILIKE"}
+	}
+	return likeSearcher{operator: "LIKE"}
+}
+
+// currentSearcher 当前使用的搜索实现
+func currentSearcher(db *gorm.DB) searcher {
+	if activeSearcher != nil {
+		return activeSearcher
+	}
+	return fallbackSearcher(db)
+}
+
+// SetupSearch 创建消息的全文索引，在自动迁移之后调用
+// 创建失败时返回错误，搜索退化为模糊匹配，仍然可用
+func SetupSearch() error {
+	indexed := indexSearcher(model.DB)
+	if err := indexed.setup(model.DB); err != nil {
+		activeSearcher = nil
+		return err
+	}
+	activeSearcher = indexed
+	return nil
+}
+
+// Search 在用户的会话主题和消息中搜索，关键词以空白分隔，需要同时包含全部关键词
+func Search(userId uint64, query string, limit int) ([]*SearchResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	var results []*SearchResult
+	var records []*Record
+	subjects := model.DB.Where("user_id = ?", userId)
+	for _, term := range terms {
+		subjects = subjects.Where("subject LIKE ? ESCAPE '!'", "%"+escapeLike(term)+"%")
+	}
+	if err := subjects.Order("updated_at DESC").Limit(limit).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		results = append(results, &SearchResult{
+			ChatID:    record.ChatID,
+			Subject:   highlight(record.Subject, terms, utf8.RuneCountInString(record.Subject)),
+			CreatedAt: record.CreatedAt,
+		})
+	}
+
+	hits, err := currentSearcher(model.DB).search(model.DB, userId, terms, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range hits {
+		results = append(results, &SearchResult{
+			ChatID:    hit.ChatID,
+			Subject:   html.EscapeString(hit.Subject),
+			MessageID: hit.ID,
+			Role:      hit.Role,
+			Snippet:   highlight(hit.Content, terms, searchSnippetLength),
+			CreatedAt: hit.CreatedAt,
+		})
+	}
+	return results, nil
+}
+
+// hitColumns 搜索结果需要查询的列
+const hitColumns = "messages.id, records.chat_id, records.subject, messages.role, messages.content, messages.created_at"
+
+// sqliteSearcher 使用FTS5的trigram分词，支持中文等不以空格分词的语言，关键词不足3个字时使用LIKE
+type sqliteSearcher struct{}
+
+func (sqliteSearcher) setup(db *gorm.DB) error {
+	var count int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'messages_fts'").Scan(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"CREATE VIRTUAL TABLE messages_fts USING fts5(content, content='messages', content_rowid='id', tokenize='trigram')",
+			"CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN " +
+				"INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content); END",
+			"CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN " +
+				"INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content); END",
+			"CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN " +
+				"INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content); " +
+				"INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content); END",
+			// 为已有的消息建立索引
+			"INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')",
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (sqliteSearcher) search(db *gorm.DB, userId uint64, terms []string, limit int) (hits []*searchHit, err error) {
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < 3 {
+			return likeSearcher{operator: "LIKE"}.search(db, userId, terms, limit)
+		}
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	err = db.Table("messages_fts").Select(hitColumns).
+		Joins("JOIN messages ON messages.id = messages_fts.rowid").
+		Joins("JOIN records ON records.id = messages.record_id").
+		Where("messages_fts MATCH ? AND records.user_id = ?", strings.Join(phrases, " "), userId).
+		Order("messages_fts.rank").Limit(limit).Scan(&hits).Error
+	return
+}
+
+// mysqlSearcher 使用ngram分词的FULLTEXT索引，需要MySQL 5.7.6及以上版本
+type mysqlSearcher struct{}
+
+func (mysqlSearcher) setup(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&Message{}, "idx_messages_content_fulltext") {
+		return nil
+	}
+	return db.Exec("ALTER TABLE messages ADD FULLTEXT INDEX idx_messages_content_fulltext (content) WITH PARSER ngram").Error
+}
+
+func (mysqlSearcher) search(db *gorm.DB, userId uint64, terms []string, limit int) (hits []*searchHit, err error) {
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		phrases = append(phrases, `+"`+strings.ReplaceAll(term, `"`, ``)+`"`)
+	}
+	against := strings.Join(phrases, " ")
+	err = db.Table("messages").Select(hitColumns).
+		Joins("JOIN records ON records.id = messages.record_id").
+		Where("MATCH(messages.content) AGAINST(? IN BOOLEAN MODE) AND records.user_id = ?", against, userId).
+		Clauses(orderBy("MATCH(messages.content) AGAINST(? IN BOOLEAN MODE) DESC", against)).
+		Limit(limit).Scan(&hits).Error
+	return
+}
+
+// postgresSearcher 使用pg_trgm的GIN三元组索引加速模糊匹配，中文等不以空格分词的语言和nginx.conf这类带标点的关键词都能匹配任意片段
+// 按关键词与消息中最相近片段的相似度排序，相似度相同时较新的消息在前
+type postgresSearcher struct{}
+
+func (postgresSearcher) setup(db *gorm.DB) error {
+	// 没有权限安装扩展时返回错误，退化为不使用索引的ILIKE
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING GIN (content gin_trgm_ops)").Error
+}
+
+func (postgresSearcher) search(db *gorm.DB, userId uint64, terms []string, limit int) (hits []*searchHit, err error) {
+	err = likeQuery(db, userId, terms, "ILIKE").
+		Clauses(orderBy("word_similarity(?, messages.content) DESC, messages.id DESC", strings.Join(terms, " "))).
+		Limit(limit).Scan(&hits).Error
+	return
+}
+
+// likeSearcher 没有全文索引时逐条模糊匹配，按时间倒序排列
+type likeSearcher struct {
+	operator string
+}
+
+func (likeSearcher) setup(*gorm.DB) error {
+	return nil
+}
+
+func (s likeSearcher) search(db *gorm.DB, userId uint64, terms []string, limit int) (hits []*searchHit, err error) {
+	err = likeQuery(db, userId, terms, s.operator).Order("messages.id DESC").Limit(limit).Scan(&hits).Error
+	return
+}
+
+// likeQuery 以operator模糊匹配用户包含全部关键词的消息
+func likeQuery(db *gorm.DB, userId uint64, terms []string, operator string) *gorm.DB {
+	query := db.Table("messages").Select(hitColumns).
+		Joins("JOIN records ON records.id = messages.record_id").
+		Where("records.user_id = ?", userId)
+	for _, term := range terms {
+		query = query.Where("messages.content "+operator+" ? ESCAPE '!'", "%"+escapeLike(term)+"%")
+	}
+	return query
+}
+
+// orderBy 按带参数的表达式排序，gorm的Order不支持带参数的表达式
+func orderBy(sql string, vars ...interface{}) clause.Expression {
+	return clause.OrderBy{Expression: clause.Expr{SQL: sql, Vars: vars, WithoutParentheses: true}}
+}
+
+// escapeLike 转义LIKE中的通配符，各数据库默认的转义字符不同，统一使用!
+func escapeLike(term string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "[", "![").Replace(term)
+}
+
+// highlight 截取第一个关键词附近最多length个字，转义HTML后以<mark>标出关键词
+func highlight(text string, terms []string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// 大小写转换改变了长度时不做大小写无关匹配
+		lower = runes
+	}
+
+	// 以第一个出现的关键词为中心截取
+	start := 0
+	for _, term := range terms {
+		if i := indexRunes(lower, []rune(strings.ToLower(term))); i >= 0 {
+			start = i - length/4
+			break
+		}
+	}
+	if start < 0 || len(runes) <= length {
+		start = 0
+	}
+	end := start + length
+	if end > len(runes) {
+		end = len(runes)
+		if start = end - length; start < 0 {
+			start = 0
+		}
+	}
+
+	marked := make([]bool, end-start)
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		for i := start; i+len(needle) <= end; i++ {
+			if len(needle) > 0 && string(lower[i:i+len(needle)]) == string(needle) {
+				for j := range needle {
+					marked[i-start+j] = true
+				}
+			}
+		}
+	}
+
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i-start] && (i == start || !marked[i-start-1]) {
+			builder.WriteString("<mark>")
+		}
+		builder.WriteString(html.EscapeString(string(runes[i])))
+		if marked[i-start] && (i == end-1 || !marked[i-start+1]) {
+			builder.WriteString("</mark>")
+		}
+	}
+	if end < len(runes) {
+		builder.WriteString("…")
+	}
+	return builder.String()
+}
+
+// indexRunes 查找needle在s中第一次出现的位置
+func indexRunes(s []rune, needle []rune) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := 0; i+len(needle) <= len(s); i++ {
+		if string(s[i:i+len(needle)]) == string(needle) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{"nginx.conf", "nginx.conf"},
+		{"100%", "100!%"},
+		{"a_b", "a!_b"},
+		{"[x]", "![x]"},
+		{"wow!", "wow!!"},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.term); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		terms  []string
+		length int
+		want   string
+	}{
+		{"single term", "edit nginx.conf now", []string{"nginx.conf"}, 100, "edit <mark>nginx.conf</mark> now"},
+		{"case insensitive", "Hello World", []string{"world"}, 100, "Hello <mark>World</mark>"},
+		{"chinese substring", "如何配置反向代理服务器", []string{"反向代理"}, 100, "如何配置<mark>反向代理</mark>服务器"},
+		{"adjacent terms merged", "abcdef", []string{"abc", "def"}, 100, "<mark>abcdef</mark>"},
+		{"escape html", "<b>bold</b>", []string{"bold"}, 100, "&lt;b&gt;<mark>bold</mark>&lt;/b&gt;"},
+		{"collapse whitespace", "a\n\n  b", []string{"b"}, 100, "a <mark>b</mark>"},
+		{"no match", "nothing here", []string{"zzz"}, 100, "nothing here"},
+		{"truncate without match", "0123456789abcdefghij", []string{"k"}, 8, "01234567…"},
+		{"window at end", "0123456789abcdefghij", []string{"f"}, 8, "…cde<mark>f</mark>ghij"},
+		{"window in middle", "0123456789abcdefghij", []string{"a"}, 8, "…89<mark>a</mark>bcdef…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.text, tt.terms, tt.length); got != tt.want {
+				t.Errorf("highlight() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	setupDB(t)
+	if err := SetupSearch(); err != nil {
+		t.Fatal(err)
+	}
+	record := &Record{UserID: 1, ChatID: "search", Subject: "部署"}
+	other := &Record{UserID: 2, ChatID: "other", Subject: "other"}
+	if err := CreateRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateRecord(other); err != nil {
+		t.Fatal(err)
+	}
+	err := AppendMessages(record,
+		&Message{Role: "user", Content: "如何配置反向代理服务器"},
+		&Message{Role: "assistant", Content: "修改nginx.conf中的server块"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = AppendMessages(other, &Message{Role: "user", Content: "反向代理"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"反向代理", 1},
+		{"代理", 1},
+		{"nginx.conf", 1},
+		{"nginx 反向", 0},
+		{"部署", 1},
+		{"missing", 0},
+	}
+	for _, tt := range tests {
+		results, err := Search(1, tt.query, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != tt.want {
+			t.Errorf("Search(%q) = %d results, want %d", tt.query, len(results), tt.want)
+		}
+	}
+	if _, err := Search(1, "  ", 20); err != ErrEmptyQuery {
+		t.Errorf("Search(blank) error = %v, want ErrEmptyQuery", err)
+	}
+}
+
+// TestSearcherOrder 检查各数据库的搜索语句按相关度排序，sqlite无法执行MySQL和PostgreSQL的函数，只生成语句
+func TestSearcherOrder(t *testing.T) {
+	setupDB(t)
+	var statement string
+	err := model.DB.Callback().Row().After("gorm:row").Register("test:capture", func(db *gorm.DB) {
+		statement = db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRun := model.DB.Session(&gorm.Session{DryRun: true})
+
+	tests := []struct {
+		name     string
+		searcher searcher
+		want     string
+	}{
+		{"mysql", mysqlSearcher{}, `ORDER BY MATCH(messages.content) AGAINST("+\"nginx\"" IN BOOLEAN MODE) DESC`},
+		{"postgres", postgresSearcher{}, `ORDER BY word_similarity("nginx conf", messages.content) DESC, messages.id DESC`},
+		{"like", likeSearcher{operator: "ILIKE"}, `messages.content ILIKE "%conf%" ESCAPE '!' ORDER BY messages.id DESC`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statement = ""
+			terms := []string{"nginx", "conf"}
+			if tt.name == "mysql" {
+				terms = terms[:1]
+			}
+			// 只生成语句不执行，Scan返回不支持DryRun的错误
+			if _, err := tt.searcher.search(dryRun, 1, terms, 20); err != nil && !errors.Is(err, gorm.ErrDryRunModeUnsupported) {
+				t.Fatal(err)
+			}
+			if !strings.Contains(statement, tt.want) {
+				t.Errorf("statement = %s, want containing %s", statement, tt.want)
+			}
+		})
+	}
+}
+
+// TestSearchFallback 全文索引创建失败时退化为模糊匹配
+func TestSearchFallback(t *testing.T) {
+	setupDB(t)
+	record := &Record{UserID: 1, ChatID: "fallback", Subject: "subject"}
+	if err := CreateRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendMessages(record, &Message{Role: "user", Content: "edit nginx.conf"}); err != nil {
+		t.Fatal(err)
+	}
+	// 占用FTS5索引的表名，使创建索引失败
+	if err := model.DB.Exec("CREATE TABLE messages_fts_data (id INTEGER)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := SetupSearch(); err == nil {
+		t.Fatal("SetupSearch() error = nil, want error")
+	}
+	results, err := Search(1, "nginx.conf", 20)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Search() = %d results, want 1", len(results))
+	}
+}
@@ -0,0 +1,236 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// ErrShareNotFound 分享不存在、已过期或已撤销
+var ErrShareNotFound = errors.New("分享不存在或已失效")
+
+// ErrChatExists 复制分享时指定的会话ID已被使用
+var ErrChatExists = errors.New("会话ID已存在")
+
+// ErrShareLocked 分享密码连续输错次数过多，暂时禁止尝试
+var ErrShareLocked = errors.New("访问密码错误次数过多，请稍后再试")
+
+const (
+	// ShareMaxAttempts 锁定前允许连续输错密码的次数
+	ShareMaxAttempts = 5
+	// shareLockBase 第一次锁定的时长，之后每次锁定时长翻倍
+	shareLockBase = time.Minute
+	// shareLockMax 单次锁定的最长时长
+	shareLockMax = 24 * time.Hour
+)
+
+// SharedMessage 分享快照中的消息
+type SharedMessage struct {
+	Role      string
+	Content   string
+	Model     string
+	Tokens    int
+	CreatedAt time.Time
+}
+
+// Share 会话的公开分享，保存创建时当前分支的快照，之后会话的修改不影响分享内容
+type Share struct {
+	model.BaseModel
+	Token    string `gorm:"column:token;type:varchar(64);not null;unique" valid:"token"`
+	UserID   uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	RecordID uint64 `gorm:"column:record_id;type:bigint(20);not null;index" valid:"record_id"`
+	Subject  string `gorm:"column:subject;type:varchar(255);not null" valid:"subject"`
+	// Messages 分享时的对话快照
+	Messages []SharedMessage `gorm:"column:messages;serializer:json" valid:"messages"`
+	// Password 访问密码的hash，空表示无需密码
+	Password  string     `gorm:"column:password;type:varchar(255);not null;default:''" valid:"password"`
+	ExpiresAt *time.Time `gorm:"column:expires_at" valid:"expires_at"`
+	Revoked   bool       `gorm:"column:revoked;type:bool;not null;default:false" valid:"revoked"`
+	Views     int64      `gorm:"column:views;not null;default:0" valid:"views"`
+	// FailedAttempts 本轮连续输错密码的次数，包括正在校验的尝试
+	FailedAttempts int `gorm:"column:failed_attempts;not null;default:0" valid:"failed_attempts"`
+	// Lockouts 密码输对之前已经锁定的次数，用于计算下次锁定时长
+	Lockouts    int        `gorm:"column:lockouts;not null;default:0" valid:"lockouts"`
+	LockedUntil *time.Time `gorm:"column:locked_until" valid:"locked_until"`
+}
+
+// HasPassword 是否需要密码访问
+func (s *Share) HasPassword() bool {
+	return s.Password != ""
+}
+
+// Available 分享未撤销且未过期
+func (s *Share) Available(now time.Time) bool {
+	return !s.Revoked && (s.ExpiresAt == nil || s.ExpiresAt.After(now))
+}
+
+// Locked 分享是否因密码错误次数过多而锁定
+func (s *Share) Locked(now time.Time) bool {
+	return s.LockedUntil != nil && s.LockedUntil.After(now)
+}
+
+// shareLockDuration 第lockouts+1次锁定的时长
+func shareLockDuration(lockouts int) time.Duration {
+	duration := shareLockBase
+	for i := 0; i < lockouts && duration < shareLockMax; i++ {
+		duration *= 2
+	}
+	if duration > shareLockMax {
+		duration = shareLockMax
+	}
+	return duration
+}
+
+// BeginSharePassword 校验分享密码前占用一次尝试机会，锁定中或机会已用完时返回ErrShareLocked
+// 先计数再校验，并发请求也最多同时尝试ShareMaxAttempts次，不受访问者IP的影响
+func BeginSharePassword(share *Share, now time.Time) error {
+	result := model.DB.Model(&Share{}).
+		Where("id = ? AND failed_attempts < ? AND (locked_until IS NULL OR locked_until <= ?)", share.ID, ShareMaxAttempts, now).
+		UpdateColumn("failed_attempts", gorm.Expr("failed_attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 机会已用完但还没有锁定时（如校验中的请求异常退出）在这里补上锁定
+		if err := lockShare(share, now); err != nil {
+			return err
+		}
+		return ErrShareLocked
+	}
+	return nil
+}
+
+// EndSharePassword 记录密码校验结果，输对时清零计数，本轮机会用完且输错时锁定分享
+func EndSharePassword(share *Share, ok bool, now time.Time) error {
+	if ok {
+		return model.DB.Model(&Share{}).Where("id = ?", share.ID).
+			UpdateColumns(map[string]interface{}{"failed_attempts": 0, "lockouts": 0, "locked_until": nil}).Error
+	}
+	return lockShare(share, now)
+}
+
+// lockShare 本轮机会用完时锁定分享，锁定时长随锁定次数翻倍
+func lockShare(share *Share, now time.Time) error {
+	current := &Share{}
+	err := model.DB.Select("id", "failed_attempts", "lockouts", "locked_until").First(current, share.ID).Error
+	if err != nil {
+		return err
+	}
+	if current.FailedAttempts < ShareMaxAttempts {
+		return nil
+	}
+	until := now.Add(shareLockDuration(current.Lockouts))
+	return model.DB.Model(&Share{}).Where("id = ? AND failed_attempts >= ?", share.ID, ShareMaxAttempts).
+		UpdateColumns(map[string]interface{}{
+			"failed_attempts": 0,
+			"lockouts":        gorm.Expr("lockouts + 1"),
+			"locked_until":    until,
+		}).Error
+}
+
+// CreateShare 以会话主题和path创建分享，passwordHash为空表示无需密码，expiresAt为nil表示永不过期
+func CreateShare(record *Record, path []*Message, passwordHash string, expiresAt *time.Time) (share *Share, err error) {
+	token, err := randomToken()
+	if err != nil {
+		return
+	}
+	messages := make([]SharedMessage, 0, len(path))
+	for _, message := range path {
+		messages = append(messages, SharedMessage{
+			Role:      message.Role,
+			Content:   message.Content,
+			Model:     message.Model,
+			Tokens:    message.Tokens,
+			CreatedAt: message.CreatedAt,
+		})
+	}
+	share = &Share{
+		Token:     token,
+		UserID:    record.UserID,
+		RecordID:  record.ID,
+		Subject:   record.Subject,
+		Messages:  messages,
+		Password:  passwordHash,
+		ExpiresAt: expiresAt,
+	}
+	err = model.DB.Create(share).Error
+	return
+}
+
+// SelectShares 查询用户的分享，recordId为0表示全部会话，按创建时间倒序排列
+func SelectShares(userId uint64, recordId uint64) (shares []*Share, err error) {
+	query := model.DB.Omit("messages").Where("user_id = ?", userId)
+	if recordId != 0 {
+		query = query.Where("record_id = ?", recordId)
+	}
+	err = query.Order("id DESC").Find(&shares).Error
+	return
+}
+
+// SelectAvailableShare 按token查询可以访问的分享
+func SelectAvailableShare(token string) (share *Share, err error) {
+	share = &Share{}
+	err = model.DB.Where("token = ?", token).First(share).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !share.Available(time.Now())) {
+		return nil, ErrShareNotFound
+	}
+	return
+}
+
+// AddShareView 增加分享的访问次数
+func AddShareView(share *Share) error {
+	return model.DB.Model(share).UpdateColumn("views", gorm.Expr("views + 1")).Error
+}
+
+// RevokeShare 撤销用户的分享，撤销后链接无法再访问
+func RevokeShare(userId uint64, id uint64) error {
+	result := model.DB.Model(&Share{}).Where("id = ? AND user_id = ?", id, userId).UpdateColumn("revoked", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// ForkShare 把分享的对话复制为用户的新会话
+func ForkShare(share *Share, userId uint64, chatId string) (record *Record, err error) {
+	record = &Record{UserID: userId, ChatID: chatId, Subject: share.Subject}
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Record{}).Where("chat_id = ?", chatId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrChatExists
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		var parentId uint64
+		for _, item := range share.Messages {
+			message := &Message{RecordID: record.ID, ParentID: parentId, Role: item.Role, Content: item.Content, Model: item.Model, Tokens: item.Tokens}
+			if err := tx.Create(message).Error; err != nil {
+				return err
+			}
+			parentId = message.ID
+		}
+		return updateLeaf(tx, record, parentId)
+	})
+	return
+}
+
+// randomToken 生成分享链接中不可猜测的token
+func randomToken() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,130 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+func TestShareAvailable(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+	tests := []struct {
+		name  string
+		share Share
+		want  bool
+	}{
+		{"never expires", Share{}, true},
+		{"not expired", Share{ExpiresAt: &future}, true},
+		{"expired", Share{ExpiresAt: &past}, false},
+		{"expires now", Share{ExpiresAt: &now}, false},
+		{"revoked", Share{Revoked: true}, false},
+		{"revoked before expiry", Share{Revoked: true, ExpiresAt: &future}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.share.Available(now); got != tt.want {
+				t.Errorf("Available() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareHasPassword(t *testing.T) {
+	if (&Share{}).HasPassword() {
+		t.Error("share without password hash reports HasPassword")
+	}
+	if !(&Share{Password: "$2a$14$hash"}).HasPassword() {
+		t.Error("share with password hash reports no password")
+	}
+}
+
+func TestShareLockDuration(t *testing.T) {
+	tests := []struct {
+		lockouts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{3, 8 * time.Minute},
+		{20, 24 * time.Hour},
+		{1000, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := shareLockDuration(tt.lockouts); got != tt.want {
+			t.Errorf("shareLockDuration(%d) = %v, want %v", tt.lockouts, got, tt.want)
+		}
+	}
+}
+
+func TestSharePasswordLockout(t *testing.T) {
+	setupDB(t)
+	share := &Share{Token: "locked", Subject: "subject", Password: "hash"}
+	if err := model.DB.Create(share).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	attempt := func(ok bool) error {
+		if err := BeginSharePassword(share, now); err != nil {
+			return err
+		}
+		return EndSharePassword(share, ok, now)
+	}
+
+	for i := 0; i < ShareMaxAttempts; i++ {
+		if err := attempt(false); err != nil {
+			t.Fatalf("attempt %d error = %v", i+1, err)
+		}
+	}
+	if err := attempt(true); err != ErrShareLocked {
+		t.Fatalf("attempt after %d failures error = %v, want ErrShareLocked", ShareMaxAttempts, err)
+	}
+
+	// 第一次锁定1分钟，到期后重新计数，再次锁定时长翻倍
+	now = now.Add(time.Minute)
+	for i := 0; i < ShareMaxAttempts; i++ {
+		if err := attempt(false); err != nil {
+			t.Fatalf("attempt %d after unlock error = %v", i+1, err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if err := attempt(true); err != ErrShareLocked {
+		t.Fatalf("second lockout error = %v, want ErrShareLocked", err)
+	}
+	now = now.Add(time.Minute)
+	if err := attempt(true); err != nil {
+		t.Fatalf("attempt after second lockout error = %v", err)
+	}
+
+	// 输对后清零，重新允许ShareMaxAttempts次
+	stored := &Share{}
+	if err := model.DB.First(stored, share.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.FailedAttempts != 0 || stored.Lockouts != 0 || stored.LockedUntil != nil {
+		t.Errorf("after success = %d failures, %d lockouts, locked until %v", stored.FailedAttempts, stored.Lockouts, stored.LockedUntil)
+	}
+}
+
+func TestSharePasswordConcurrentAttempts(t *testing.T) {
+	setupDB(t)
+	share := &Share{Token: "concurrent", Subject: "subject", Password: "hash"}
+	if err := model.DB.Create(share).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// 校验还没有结束的尝试也占用机会，同时发起的请求最多ShareMaxAttempts个能开始校验
+	allowed := 0
+	for i := 0; i < ShareMaxAttempts*2; i++ {
+		if err := BeginSharePassword(share, now); err == nil {
+			allowed++
+		} else if err != ErrShareLocked {
+			t.Fatal(err)
+		}
+	}
+	if allowed != ShareMaxAttempts {
+		t.Errorf("allowed attempts = %d, want %d", allowed, ShareMaxAttempts)
+	}
+}
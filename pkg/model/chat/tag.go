@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"gorm.io/gorm/clause"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Tag 会话的标签，每行一个标签，便于在各种数据库中按标签筛选
+type Tag struct {
+	ID       uint64 `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	UserID   uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	RecordID uint64 `gorm:"column:record_id;type:bigint(20);not null;uniqueIndex:idx_chat_tags_record_name" valid:"record_id"`
+	Name     string `gorm:"column:name;type:varchar(64);not null;uniqueIndex:idx_chat_tags_record_name" valid:"name"`
+}
+
+// TableName 避免与其他模块的标签混淆
+func (Tag) TableName() string {
+	return "chat_tags"
+}
+
+// TagCount 标签及使用该标签的会话数
+type TagCount struct {
+	Name  string
+	Count int64
+}
+
+// SelectTags 查询会话的标签，返回会话ID到标签的映射
+func SelectTags(recordIds []uint64) (map[uint64][]string, error) {
+	result := make(map[uint64][]string, len(recordIds))
+	if len(recordIds) == 0 {
+		return result, nil
+	}
+	var tags []*Tag
+	err := model.DB.Where("record_id IN ?", recordIds).Order("id ASC").Find(&tags).Error
+	for _, tag := range tags {
+		result[tag.RecordID] = append(result[tag.RecordID], tag.Name)
+	}
+	return result, err
+}
+
+// CountTags 统计用户的全部标签
+func CountTags(userId uint64) (items []*TagCount, err error) {
+	err = model.DB.Model(&Tag{}).Select("name, COUNT(*) AS count").Where("user_id = ?", userId).
+		Group("name").Order("name ASC").Scan(&items).Error
+	return
+}
+
+// AddTags 为会话添加标签，已有的标签忽略
+func AddTags(records []*Record, names []string) error {
+	var tags []*Tag
+	for _, record := range records {
+		for _, name := range names {
+			tags = append(tags, &Tag{UserID: record.UserID, RecordID: record.ID, Name: name})
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return model.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error
+}
+
+// RemoveTags 移除会话的标签
+func RemoveTags(records []*Record, names []string) error {
+	if len(records) == 0 || len(names) == 0 {
+		return nil
+	}
+	return model.DB.Where("record_id IN ? AND name IN ?", recordIds(records), names).Delete(&Tag{}).Error
+}
+
+func recordIds(records []*Record) []uint64 {
+	ids := make([]uint64, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
@@ -0,0 +1,66 @@
+package credit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+const (
+	// KindDebit 对话扣费
+	KindDebit = "debit"
+	// KindRedeem 兑换码充值
+	KindRedeem = "redeem"
+	// KindAdjust 管理员调整
+	KindAdjust = "adjust"
+	// KindRefund 上游调用失败，退回预扣的余额
+	KindRefund = "refund"
+)
+
+const (
+	// StatusPending 已预扣，等待上游返回后按实际用量结算
+	StatusPending = "pending"
+	// StatusSettled 已结算
+	StatusSettled = "settled"
+	// StatusReleased 预扣已通过退款流水全部退回
+	StatusReleased = "released"
+)
+
+// ErrInsufficient 余额不足
+var ErrInsufficient = errors.New("账户余额不足，请使用兑换码充值或联系管理员")
+
+// ErrInvalidCode 兑换码无效
+var ErrInvalidCode = errors.New("兑换码无效、已被使用或已过期")
+
+// Account 用户余额账户
+type Account struct {
+	model.BaseModel
+	UserID  uint64  `gorm:"column:user_id;type:bigint(20);not null;unique" valid:"user_id"`
+	Balance float64 `gorm:"column:balance;not null;default:0" valid:"balance"`
+}
+
+// Ledger 余额流水，金额为正表示入账，为负表示扣费
+type Ledger struct {
+	model.BaseModel
+	UserID  uint64  `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	Kind    string  `gorm:"column:kind;type:varchar(16);not null" valid:"kind"`
+	Status  string  `gorm:"column:status;type:varchar(16);not null" valid:"status"`
+	Amount  float64 `gorm:"column:amount;not null" valid:"amount"`
+	Balance float64 `gorm:"column:balance;not null" valid:"balance"` // 本次变动后的余额
+	ChatID  string  `gorm:"column:chat_id;type:varchar(255);not null;default:''" valid:"chat_id"`
+	Model   string  `gorm:"column:model;type:varchar(255);not null;default:''" valid:"model"`
+	Tokens  int     `gorm:"column:tokens;not null;default:0" valid:"tokens"`
+	Remark  string  `gorm:"column:remark;type:varchar(255);not null;default:''" valid:"remark"`
+}
+
+// RedeemCode 兑换码，每个兑换码只能使用一次
+type RedeemCode struct {
+	model.BaseModel
+	Code       string     `gorm:"column:code;type:varchar(64);not null;unique" valid:"code"`
+	Amount     float64    `gorm:"column:amount;not null" valid:"amount"`
+	CreatedBy  uint64     `gorm:"column:created_by;type:bigint(20);not null" valid:"created_by"`
+	RedeemedBy uint64     `gorm:"column:redeemed_by;type:bigint(20);not null;default:0;index" valid:"redeemed_by"`
+	RedeemedAt *time.Time `gorm:"column:redeemed_at" valid:"redeemed_at"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" valid:"expires_at"`
+}
@@ -0,0 +1,247 @@
+package credit
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// GetAccount 获取用户余额账户，不存在时创建
+func GetAccount(userId uint64) (account *Account, err error) {
+	return getAccount(model.DB, userId)
+}
+
+// Hold 调用上游前按预估费用预扣余额，余额不足时返回ErrInsufficient
+// 使用带条件的原子更新，并发请求不会透支余额
+func Hold(userId uint64, amount float64, chatId string, modelName string) (entry *Ledger, err error) {
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		account, err := getAccount(tx, userId)
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&Account{}).
+			Where("id = ? AND balance >= ?", account.ID, amount).
+			Update("balance", gorm.Expr("balance - ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficient
+		}
+
+		entry = &Ledger{
+			UserID: userId,
+			Kind:   KindDebit,
+			Status: StatusPending,
+			Amount: -amount,
+			ChatID: chatId,
+			Model:  modelName,
+		}
+		return createEntry(tx, entry)
+	})
+	return
+}
+
+// Settle 按实际费用结算预扣记录，多扣的部分退回余额
+// 实际费用超出预扣金额时只收取预扣金额，保证余额不会透支
+func Settle(entry *Ledger, amount float64, tokens int) error {
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		amount = settleAmount(-entry.Amount, amount)
+		refund := -entry.Amount - amount
+		err := tx.Model(&Account{}).Where("user_id = ?", entry.UserID).
+			Update("balance", gorm.Expr("balance + ?", refund)).Error
+		if err != nil {
+			return err
+		}
+
+		account, err := getAccount(tx, entry.UserID)
+		if err != nil {
+			return err
+		}
+		entry.Amount = -amount
+		entry.Balance = account.Balance
+		entry.Tokens = tokens
+		entry.Status = StatusSettled
+		return tx.Save(entry).Error
+	})
+}
+
+// settleAmount 结算金额，不小于0且不超过预扣金额
+func settleAmount(held float64, amount float64) float64 {
+	if amount < 0 {
+		return 0
+	}
+	if amount > held {
+		return held
+	}
+	return amount
+}
+
+// Release 上游调用失败时退回预扣的全部余额
+// 预扣记录保留并标记为已退回，另写一条退款流水，流水只增不删
+func Release(entry *Ledger) error {
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Account{}).Where("user_id = ?", entry.UserID).
+			Update("balance", gorm.Expr("balance - ?", entry.Amount)).Error
+		if err != nil {
+			return err
+		}
+		entry.Status = StatusReleased
+		if err = tx.Model(entry).Update("status", StatusReleased).Error; err != nil {
+			return err
+		}
+		return createEntry(tx, &Ledger{
+			UserID: entry.UserID,
+			Kind:   KindRefund,
+			Status: StatusSettled,
+			Amount: -entry.Amount,
+			ChatID: entry.ChatID,
+			Model:  entry.Model,
+		})
+	})
+}
+
+// Adjust 管理员调整余额，amount为负数表示扣减，扣减后余额不能为负
+func Adjust(userId uint64, amount float64, remark string) (entry *Ledger, err error) {
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		account, err := getAccount(tx, userId)
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&Account{}).
+			Where("id = ? AND balance + ? >= 0", account.ID, amount).
+			Update("balance", gorm.Expr("balance + ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficient
+		}
+
+		entry = &Ledger{
+			UserID: userId,
+			Kind:   KindAdjust,
+			Status: StatusSettled,
+			Amount: amount,
+			Remark: remark,
+		}
+		return createEntry(tx, entry)
+	})
+	return
+}
+
+// Redeem 使用兑换码充值
+func Redeem(userId uint64, code string) (entry *Ledger, err error) {
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// 先占用兑换码，保证同一兑换码只能被使用一次
+		result := tx.Model(&RedeemCode{}).
+			Where("code = ? AND redeemed_by = 0 AND (expires_at IS NULL OR expires_at > ?)", code, now).
+			Updates(map[string]interface{}{"redeemed_by": userId, "redeemed_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidCode
+		}
+
+		redeemCode := &RedeemCode{}
+		if err := tx.Where("code = ?", code).First(redeemCode).Error; err != nil {
+			return err
+		}
+		account, err := getAccount(tx, userId)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(account).Update("balance", gorm.Expr("balance + ?", redeemCode.Amount)).Error
+		if err != nil {
+			return err
+		}
+
+		entry = &Ledger{
+			UserID: userId,
+			Kind:   KindRedeem,
+			Status: StatusSettled,
+			Amount: redeemCode.Amount,
+			Remark: code,
+		}
+		return createEntry(tx, entry)
+	})
+	return
+}
+
+// GenerateCodes 批量生成兑换码，expiresAt为nil表示永不过期
+func GenerateCodes(count int, amount float64, createdBy uint64, expiresAt *time.Time) (codes []*RedeemCode, err error) {
+	for i := 0; i < count; i++ {
+		code, err := randomCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, &RedeemCode{
+			Code:      code,
+			Amount:    amount,
+			CreatedBy: createdBy,
+			ExpiresAt: expiresAt,
+		})
+	}
+	err = model.DB.Create(&codes).Error
+	return
+}
+
+// ListCodes 兑换码列表，按创建时间倒序排列
+func ListCodes(onlyUnused bool) (codes []*RedeemCode, err error) {
+	query := model.DB.Order("id DESC")
+	if onlyUnused {
+		query = query.Where("redeemed_by = 0")
+	}
+	err = query.Find(&codes).Error
+	return
+}
+
+// ListLedger 查询流水，userId为0表示全部用户，start、end为空表示不限制，limit为0表示不限制条数
+func ListLedger(userId uint64, start *time.Time, end *time.Time, limit int) (entries []*Ledger, err error) {
+	query := model.DB.Order("id DESC")
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if start != nil {
+		query = query.Where("created_at >= ?", *start)
+	}
+	if end != nil {
+		query = query.Where("created_at < ?", *end)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err = query.Find(&entries).Error
+	return
+}
+
+func getAccount(tx *gorm.DB, userId uint64) (account *Account, err error) {
+	account = &Account{}
+	err = tx.Where(Account{UserID: userId}).FirstOrCreate(account).Error
+	return
+}
+
+// createEntry 写入流水，并记录变动后的余额
+func createEntry(tx *gorm.DB, entry *Ledger) error {
+	account, err := getAccount(tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+	entry.Balance = account.Balance
+	return tx.Create(entry).Error
+}
+
+// randomCode 生成随机兑换码
+func randomCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,153 @@
+package credit
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// setupDB 每个测试使用独立的内存数据库
+func setupDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&Account{}, &Ledger{}); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+}
+
+// deposit 为用户充值
+func deposit(t *testing.T, userId uint64, amount float64) {
+	t.Helper()
+	if _, err := Adjust(userId, amount, "test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func balance(t *testing.T, userId uint64) float64 {
+	t.Helper()
+	account, err := GetAccount(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account.Balance
+}
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSettleAmount(t *testing.T) {
+	tests := []struct {
+		name   string
+		held   float64
+		amount float64
+		want   float64
+	}{
+		{"less than held", 1, 0.4, 0.4},
+		{"equal to held", 1, 1, 1},
+		{"more than held", 1, 1.5, 1},
+		{"negative", 1, -0.1, 0},
+		{"nothing held", 0, 0.3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := settleAmount(tt.held, tt.amount); !almostEqual(got, tt.want) {
+				t.Errorf("settleAmount(%v, %v) = %v, want %v", tt.held, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldSettle(t *testing.T) {
+	tests := []struct {
+		name        string
+		hold        float64
+		actual      float64
+		wantBalance float64
+		wantAmount  float64
+	}{
+		{"refund unused part", 2, 0.5, 9.5, -0.5},
+		{"exact estimate", 2, 2, 8, -2},
+		{"cost over estimate is capped", 2, 3, 8, -2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupDB(t)
+			deposit(t, 1, 10)
+			entry, err := Hold(1, tt.hold, "chat", "gpt-4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := balance(t, 1); !almostEqual(got, 10-tt.hold) {
+				t.Fatalf("balance after hold = %v, want %v", got, 10-tt.hold)
+			}
+			if err = Settle(entry, tt.actual, 100); err != nil {
+				t.Fatal(err)
+			}
+			if got := balance(t, 1); !almostEqual(got, tt.wantBalance) {
+				t.Errorf("balance after settle = %v, want %v", got, tt.wantBalance)
+			}
+			if !almostEqual(entry.Amount, tt.wantAmount) || entry.Status != StatusSettled || !almostEqual(entry.Balance, tt.wantBalance) {
+				t.Errorf("entry = {Amount: %v, Status: %v, Balance: %v}", entry.Amount, entry.Status, entry.Balance)
+			}
+		})
+	}
+}
+
+func TestHoldInsufficient(t *testing.T) {
+	setupDB(t)
+	deposit(t, 1, 1)
+	if _, err := Hold(1, 1.5, "chat", "gpt-4"); !errors.Is(err, ErrInsufficient) {
+		t.Fatalf("Hold over balance err = %v, want ErrInsufficient", err)
+	}
+	if got := balance(t, 1); !almostEqual(got, 1) {
+		t.Errorf("balance = %v, want 1", got)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	setupDB(t)
+	deposit(t, 1, 5)
+	entry, err := Hold(1, 2, "chat", "gpt-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Release(entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, 1); !almostEqual(got, 5) {
+		t.Errorf("balance after release = %v, want 5", got)
+	}
+
+	entries, err := ListLedger(1, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 充值、预扣、退款三条流水，预扣记录保留
+	if len(entries) != 3 {
+		t.Fatalf("ledger has %d entries, want 3", len(entries))
+	}
+	refund, hold := entries[0], entries[1]
+	if refund.Kind != KindRefund || !almostEqual(refund.Amount, 2) || !almostEqual(refund.Balance, 5) {
+		t.Errorf("refund entry = {Kind: %v, Amount: %v, Balance: %v}", refund.Kind, refund.Amount, refund.Balance)
+	}
+	if hold.Kind != KindDebit || hold.Status != StatusReleased || !almostEqual(hold.Amount, -2) {
+		t.Errorf("hold entry = {Kind: %v, Status: %v, Amount: %v}", hold.Kind, hold.Status, hold.Amount)
+	}
+	var sum float64
+	for _, item := range entries {
+		sum += item.Amount
+	}
+	if !almostEqual(sum, 5) {
+		t.Errorf("ledger sum = %v, want balance 5", sum)
+	}
+}
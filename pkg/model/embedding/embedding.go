@@ -0,0 +1,104 @@
+package embedding
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Embedding 消息的向量，由后台任务计算
+type Embedding struct {
+	ID        uint64 `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	MessageID uint64 `gorm:"column:message_id;type:bigint(20);not null;unique" valid:"message_id"`
+	RecordID  uint64 `gorm:"column:record_id;type:bigint(20);not null;index" valid:"record_id"`
+	UserID    uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	Model     string `gorm:"column:model;type:varchar(255);not null" valid:"model"`
+	// Vector 以小端float32保存的向量，使用pgvector时另存在embedding列中
+	Vector []byte `gorm:"column:vector" valid:"vector"`
+}
+
+// Pending 还没有计算向量的消息
+type Pending struct {
+	MessageID uint64
+	RecordID  uint64
+	UserID    uint64
+	Content   string
+}
+
+// SelectPending 查询还没有用modelName计算向量的用户消息和回复，按创建顺序排列
+// 更换模型后旧模型的向量无法与新向量比较，视为还没有计算，excludeUsers的消息除外
+func SelectPending(modelName string, excludeUsers []uint64, limit int) (items []*Pending, err error) {
+	query := model.DB.Table("messages").
+		Select("messages.id AS message_id, messages.record_id, records.user_id, messages.content").
+		Joins("JOIN records ON records.id = messages.record_id").
+		Joins("LEFT JOIN embeddings ON embeddings.message_id = messages.id AND embeddings.model = ?", modelName).
+		Where("embeddings.id IS NULL AND messages.role IN ?", []string{"user", "assistant"})
+	if len(excludeUsers) > 0 {
+		query = query.Where("records.user_id NOT IN ?", excludeUsers)
+	}
+	err = query.Order("messages.id ASC").Limit(limit).Scan(&items).Error
+	return
+}
+
+// SelectByRecord 查询会话中已计算的向量
+func SelectByRecord(recordId uint64) (items []*Embedding, err error) {
+	err = model.DB.Where("record_id = ?", recordId).Find(&items).Error
+	return
+}
+
+// DeleteOrphans 删除消息已被删除的向量
+func DeleteOrphans() error {
+	return model.DB.Where("message_id NOT IN (?)", model.DB.Table("messages").Select("id")).Delete(&Embedding{}).Error
+}
+
+// Encode 将向量编码为字节
+func Encode(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// Decode 将字节解码为向量
+func Decode(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
+
+// Mean 多个向量的平均值，用于表示整个会话
+func Mean(vectors [][]float32) []float32 {
+	if len(vectors) == 0 {
+		return nil
+	}
+	mean := make([]float32, len(vectors[0]))
+	for _, vector := range vectors {
+		for i := range mean {
+			if i < len(vector) {
+				mean[i] += vector[i] / float32(len(vectors))
+			}
+		}
+	}
+	return mean
+}
+
+// Cosine 余弦相似度，维度不同或为零向量时返回0
+func Cosine(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
@@ -0,0 +1,145 @@
+package embedding
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+	"github.com/869413421/chatgpt-web/pkg/model/chat"
+)
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same", []float32{1, 2, 3}, []float32{1, 2, 3}, 1},
+		{"scaled", []float32{1, 2}, []float32{2, 4}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 1}, []float32{-1, -1}, -1},
+		{"different dimensions", []float32{1, 2}, []float32{1, 2, 3}, 0},
+		{"empty", nil, nil, 0},
+		{"zero vector", []float32{0, 0}, []float32{1, 1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cosine(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("Cosine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := [][]float32{
+		nil,
+		{0},
+		{0.1, -0.2, 3.5e-8, float32(math.MaxFloat32)},
+	}
+	for _, vector := range tests {
+		data := Encode(vector)
+		if len(data) != 4*len(vector) {
+			t.Errorf("Encode(%v) length = %d, want %d", vector, len(data), 4*len(vector))
+		}
+		if got := Decode(data); len(vector) > 0 && !reflect.DeepEqual(got, vector) {
+			t.Errorf("Decode(Encode(%v)) = %v", vector, got)
+		}
+	}
+}
+
+func TestMean(t *testing.T) {
+	tests := []struct {
+		name    string
+		vectors [][]float32
+		want    []float32
+	}{
+		{"empty", nil, nil},
+		{"single", [][]float32{{1, 2}}, []float32{1, 2}},
+		{"average", [][]float32{{1, 2}, {3, 6}}, []float32{2, 4}},
+		{"shorter vector", [][]float32{{2, 2}, {2}}, []float32{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Mean(tt.vectors); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mean() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectPending(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&chat.Record{}, &chat.Message{}, &Embedding{}); err != nil {
+		t.Fatal(err)
+	}
+	model.DB = db
+
+	first := &chat.Record{UserID: 1, ChatID: "first"}
+	second := &chat.Record{UserID: 2, ChatID: "second"}
+	db.Create(first)
+	db.Create(second)
+	messages := []*chat.Message{
+		{RecordID: first.ID, Role: "system", Content: "system"},
+		{RecordID: first.ID, Role: "user", Content: "hello"},
+		{RecordID: first.ID, Role: "assistant", Content: "hi"},
+		{RecordID: second.ID, Role: "user", Content: "other"},
+	}
+	db.Create(&messages)
+
+	pending, err := SelectPending("a", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 3 {
+		t.Fatalf("SelectPending() = %d items, want 3", len(pending))
+	}
+	pending, err = SelectPending("a", []uint64{2}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("SelectPending() excluding user 2 = %d items, want 2", len(pending))
+	}
+
+	err = Add([]*Embedding{
+		{MessageID: messages[1].ID, RecordID: first.ID, UserID: 1, Model: "a", Vector: Encode([]float32{1})},
+		{MessageID: messages[2].ID, RecordID: first.ID, UserID: 1, Model: "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, _ = SelectPending("a", nil, 10)
+	if len(pending) != 1 || pending[0].MessageID != messages[3].ID {
+		t.Fatalf("SelectPending() after add = %v, want message %d", pending, messages[3].ID)
+	}
+
+	// 更换模型后旧向量视为未计算，重新计算后覆盖旧向量
+	pending, _ = SelectPending("b", nil, 10)
+	if len(pending) != 3 {
+		t.Fatalf("SelectPending() with new model = %d items, want 3", len(pending))
+	}
+	err = Add([]*Embedding{{MessageID: messages[1].ID, RecordID: first.ID, UserID: 1, Model: "b", Vector: Encode([]float32{2})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := SelectByRecord(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("SelectByRecord() = %d items, want 2", len(items))
+	}
+	for _, item := range items {
+		if item.MessageID == messages[1].ID && (item.Model != "b" || Decode(item.Vector)[0] != 2) {
+			t.Errorf("embedding of message %d = %s %v, want replaced by model b", item.MessageID, item.Model, Decode(item.Vector))
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package embedding
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/869413421/chatgpt-web/pkg/logger"
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Match 向量检索的结果
+type Match struct {
+	MessageID uint64
+	RecordID  uint64
+	Score     float64
+}
+
+// Index 向量索引，保存向量并按余弦相似度检索用户的消息
+type Index interface {
+	// Setup 启动时创建索引需要的结构，需要可以重复执行
+	Setup(db *gorm.DB) error
+	// Add 保存向量
+	Add(items []*Embedding) error
+	// Search 检索用户与vector最相似的消息，按相似度从高到低排列，excludeRecordId会话中的消息除外
+	Search(userId uint64, modelName string, vector []float32, excludeRecordId uint64, limit int) ([]*Match, error)
+}
+
+var index Index = bruteForceIndex{}
+
+// defaultDimensions 没有配置维度时pgvector列的维度，与text-embedding-ada-002一致
+const defaultDimensions = 1536
+
+// SetupIndex 根据数据库选择向量索引，PostgreSQL安装了pgvector时使用pgvector，否则在进程内逐条计算
+// dimensions为向量维度，0表示使用默认的1536
+func SetupIndex(dimensions int) {
+	if dimensions <= 0 {
+		dimensions = defaultDimensions
+	}
+	if model.DB.Dialector.Name() == "postgres" {
+		candidate := pgvectorIndex{dimensions: dimensions}
+		if err := candidate.Setup(model.DB); err != nil {
+			logger.Warning("pgvector unavailable, fallback to brute force:", err)
+			return
+		}
+		index = candidate
+	}
+}
+
+// Add 保存向量
+func Add(items []*Embedding) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return index.Add(items)
+}
+
+// Search 检索用户与vector最相似的消息，excludeRecordId会话中的消息除外
+func Search(userId uint64, modelName string, vector []float32, excludeRecordId uint64, limit int) ([]*Match, error) {
+	return index.Search(userId, modelName, vector, excludeRecordId, limit)
+}
+
+// liveRecords 用户现有的会话，会话删除后向量在后台清理前不应再被检索到
+func liveRecords(userId uint64) *gorm.DB {
+	return model.DB.Table("records").Select("id").Where("user_id = ?", userId)
+}
+
+// bruteForceIndex 读取用户的全部向量逐条计算相似度，适用于SQLite等没有向量检索的数据库
+type bruteForceIndex struct{}
+
+func (bruteForceIndex) Setup(*gorm.DB) error {
+	return nil
+}
+
+func (bruteForceIndex) Add(items []*Embedding) error {
+	return save(model.DB, items)
+}
+
+func (bruteForceIndex) Search(userId uint64, modelName string, vector []float32, excludeRecordId uint64, limit int) ([]*Match, error) {
+	var items []*Embedding
+	err := model.DB.Select("message_id, record_id, vector").
+		Where("user_id = ? AND model = ? AND record_id <> ? AND record_id IN (?)", userId, modelName, excludeRecordId, liveRecords(userId)).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]*Match, 0, len(items))
+	for _, item := range items {
+		matches = append(matches, &Match{MessageID: item.MessageID, RecordID: item.RecordID, Score: Cosine(vector, Decode(item.Vector))})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// pgvectorIndex 使用pgvector扩展的embedding列检索，按余弦距离建HNSW索引
+type pgvectorIndex struct {
+	dimensions int
+}
+
+func (i pgvectorIndex) Setup(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		return err
+	}
+	column := fmt.Sprintf("vector(%d)", i.dimensions)
+	var current string
+	err := db.Raw("SELECT format_type(atttypid, atttypmod) FROM pg_attribute " +
+		"WHERE attrelid = 'embeddings'::regclass AND attname = 'embedding' AND NOT attisdropped").Scan(&current).Error
+	if err != nil {
+		return err
+	}
+	if current != "" && current != column {
+		// 维度变化后已有的向量无法再比较，清空后由后台按新维度重新计算
+		logger.Warning(fmt.Sprintf("embedding column changed from %s to %s, recompute all embeddings", current, column))
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE embeddings DROP COLUMN embedding").Error; err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM embeddings").Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err = db.Exec("ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS embedding " + column).Error; err != nil {
+		return err
+	}
+	// HNSW索引最多支持2000维，创建失败时仍可按距离逐条排序检索
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_embeddings_embedding ON embeddings USING hnsw (embedding vector_cosine_ops)").Error
+	if err != nil {
+		logger.Warning("create pgvector index error:", err)
+	}
+	return nil
+}
+
+func (i pgvectorIndex) Add(items []*Embedding) error {
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := save(tx, items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			// 空向量也要清除该消息之前其他模型的向量
+			var literal any
+			if len(item.Vector) > 0 {
+				vector := Decode(item.Vector)
+				if len(vector) != i.dimensions {
+					return fmt.Errorf("embedding dimensions mismatch: column is %d, got %d, check embedding_dimensions", i.dimensions, len(vector))
+				}
+				literal = vectorLiteral(vector)
+			}
+			err := tx.Exec("UPDATE embeddings SET embedding = ?::vector WHERE message_id = ?", literal, item.MessageID).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (pgvectorIndex) Search(userId uint64, modelName string, vector []float32, excludeRecordId uint64, limit int) (matches []*Match, err error) {
+	literal := vectorLiteral(vector)
+	err = model.DB.Raw("SELECT message_id, record_id, 1 - (embedding <=> ?::vector) AS score FROM embeddings "+
+		"WHERE user_id = ? AND model = ? AND embedding IS NOT NULL AND record_id <> ? AND record_id IN (?) "+
+		"ORDER BY embedding <=> ?::vector LIMIT ?",
+		literal, userId, modelName, excludeRecordId, liveRecords(userId), literal, limit).Scan(&matches).Error
+	return
+}
+
+// save 保存向量，消息已有其他模型的向量时覆盖
+func save(db *gorm.DB, items []*Embedding) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"record_id", "user_id", "model", "vector"}),
+	}).Create(&items).Error
+}
+
+// vectorLiteral pgvector的向量字面量，如[0.1,0.2]
+func vectorLiteral(vector []float32) string {
+	values := make([]string, 0, len(vector))
+	for _, value := range vector {
+		values = append(values, strconv.FormatFloat(float64(value), 'g', -1, 32))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(values, ","))
+}
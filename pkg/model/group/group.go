@@ -0,0 +1,52 @@
+package group
+
+import (
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Group 用户组，用于按组设置预算等
+type Group struct {
+	model.BaseModel
+	Name        string `gorm:"column:name;type:varchar(255);not null;unique" valid:"name"`
+	Description string `gorm:"column:description;type:varchar(255);not null;default:''" valid:"description"`
+	// 组内用户默认的模型及参数
+	model.Params
+}
+
+// Get 根据ID获取用户组
+func Get(id uint64) (group *Group, err error) {
+	group = &Group{}
+	err = model.DB.Where("id = ?", id).First(group).Error
+	return
+}
+
+// List 获取全部用户组
+func List() (groups []*Group, err error) {
+	err = model.DB.Order("id ASC").Find(&groups).Error
+	return
+}
+
+// Save 创建或更新用户组，id为0时创建
+func Save(id uint64, name string, description string, params model.Params) (group *Group, err error) {
+	group = &Group{}
+	if id != 0 {
+		err = model.DB.Where("id = ?", id).First(group).Error
+		if err != nil {
+			return
+		}
+	}
+	group.Name = name
+	group.Description = description
+	group.Params = params
+	err = model.DB.Save(group).Error
+	return
+}
+
+// Delete 删除用户组，组内用户移出该组
+func Delete(id uint64) error {
+	err := model.DB.Table("users").Where("group_id = ?", id).Update("group_id", 0).Error
+	if err != nil {
+		return err
+	}
+	return model.DB.Delete(&Group{}, id).Error
+}
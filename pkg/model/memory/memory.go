@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+const (
+	// SourceManual 用户手动添加
+	SourceManual = "manual"
+	// SourceExtract 从会话中提取
+	SourceExtract = "extract"
+)
+
+// Memory 用户的长期记忆，跨会话注入系统提示词
+type Memory struct {
+	model.BaseModel
+	UserID  uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	Content string `gorm:"column:content;type:text;not null" valid:"content"`
+	Source  string `gorm:"column:source;type:varchar(32);not null;default:'manual'" valid:"source"`
+	// ChatID 提取来源的会话
+	ChatID string `gorm:"column:chat_id;type:varchar(255);not null;default:''" valid:"chat_id"`
+}
+
+// List 获取用户的全部记忆，按创建顺序排列
+func List(userId uint64) (memories []*Memory, err error) {
+	err = model.DB.Where("user_id = ?", userId).Order("id ASC").Find(&memories).Error
+	return
+}
+
+// Save 创建或更新用户的记忆，id为0时创建
+func Save(userId uint64, id uint64, content string) (memory *Memory, err error) {
+	memory = &Memory{UserID: userId, Source: SourceManual}
+	if id != 0 {
+		err = model.DB.Where("id = ? AND user_id = ?", id, userId).First(memory).Error
+		if err != nil {
+			return
+		}
+	}
+	memory.Content = content
+	err = model.DB.Save(memory).Error
+	return
+}
+
+// Create 批量添加记忆
+func Create(memories []*Memory) error {
+	if len(memories) == 0 {
+		return nil
+	}
+	return model.DB.Create(&memories).Error
+}
+
+// Delete 删除用户的记忆
+func Delete(userId uint64, id uint64) error {
+	return model.DB.Where("id = ? AND user_id = ?", id, userId).Delete(&Memory{}).Error
+}
@@ -0,0 +1,35 @@
+package model
+
+// Params 模型及采样参数，可嵌入会话、用户设置、用户组等模型中
+// 字段为空表示未设置，由下一级的设置决定
+type Params struct {
+	Model            string   `gorm:"column:model;type:varchar(255);not null;default:''" valid:"model"`
+	Temperature      *float32 `gorm:"column:temperature" valid:"temperature"`
+	TopP             *float32 `gorm:"column:top_p" valid:"top_p"`
+	PresencePenalty  *float32 `gorm:"column:presence_penalty" valid:"presence_penalty"`
+	FrequencyPenalty *float32 `gorm:"column:frequency_penalty" valid:"frequency_penalty"`
+	MaxTokens        int      `gorm:"column:max_tokens;not null;default:0" valid:"max_tokens"`
+}
+
+// Merge 用fallback补全未设置的参数，返回合并后的参数
+func (p Params) Merge(fallback Params) Params {
+	if p.Model == "" {
+		p.Model = fallback.Model
+	}
+	if p.Temperature == nil {
+		p.Temperature = fallback.Temperature
+	}
+	if p.TopP == nil {
+		p.TopP = fallback.TopP
+	}
+	if p.PresencePenalty == nil {
+		p.PresencePenalty = fallback.PresencePenalty
+	}
+	if p.FrequencyPenalty == nil {
+		p.FrequencyPenalty = fallback.FrequencyPenalty
+	}
+	if p.MaxTokens == 0 {
+		p.MaxTokens = fallback.MaxTokens
+	}
+	return p
+}
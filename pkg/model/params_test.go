@@ -0,0 +1,30 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParamsMerge(t *testing.T) {
+	low, high := float32(0.2), float32(0.9)
+	zero := float32(0)
+	tests := []struct {
+		name     string
+		params   Params
+		fallback Params
+		want     Params
+	}{
+		{"empty uses fallback", Params{}, Params{Model: "gpt-4", Temperature: &high, MaxTokens: 100}, Params{Model: "gpt-4", Temperature: &high, MaxTokens: 100}},
+		{"set fields kept", Params{Model: "gpt-3.5", Temperature: &low, MaxTokens: 50}, Params{Model: "gpt-4", Temperature: &high, MaxTokens: 100}, Params{Model: "gpt-3.5", Temperature: &low, MaxTokens: 50}},
+		{"zero pointer is set", Params{Temperature: &zero}, Params{Temperature: &high}, Params{Temperature: &zero}},
+		{"fields merged independently", Params{TopP: &low}, Params{PresencePenalty: &high, FrequencyPenalty: &low}, Params{TopP: &low, PresencePenalty: &high, FrequencyPenalty: &low}},
+		{"both empty", Params{}, Params{}, Params{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.params.Merge(tt.fallback); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package persona
+
+import (
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Persona 角色，包含系统提示词及模型参数，可以从角色开始新的会话
+// UserID为0的是管理员维护的全局角色，否则为用户自己的角色
+type Persona struct {
+	model.BaseModel
+	UserID       uint64 `gorm:"column:user_id;type:bigint(20);not null;default:0;index" valid:"user_id"`
+	Name         string `gorm:"column:name;type:varchar(64);not null" valid:"name"`
+	Description  string `gorm:"column:description;type:varchar(255);not null;default:''" valid:"description"`
+	SystemPrompt string `gorm:"column:system_prompt;type:text;not null" valid:"system_prompt"`
+	// 角色使用的模型及参数，优先于用户和全局的设置
+	model.Params
+}
+
+// IsGlobal 是否为全局角色
+func (p *Persona) IsGlobal() bool {
+	return p.UserID == 0
+}
+
+// VisibleTo 用户是否可以使用该角色
+func (p *Persona) VisibleTo(userId uint64) bool {
+	return p.IsGlobal() || p.UserID == userId
+}
+
+// Get 根据ID获取角色
+func Get(id uint64) (persona *Persona, err error) {
+	persona = &Persona{}
+	err = model.DB.Where("id = ?", id).First(persona).Error
+	return
+}
+
+// List 获取用户可用的角色，全局角色在前
+func List(userId uint64) (personas []*Persona, err error) {
+	err = model.DB.Where("user_id = 0 OR user_id = ?", userId).Order("user_id ASC, id ASC").Find(&personas).Error
+	return
+}
+
+// Save 创建或更新角色，ID为0时创建
+func Save(persona *Persona) error {
+	return model.DB.Save(persona).Error
+}
+
+// Delete 删除角色，使用该角色的会话之后按用户和全局的设置回复
+func Delete(id uint64) error {
+	return model.DB.Delete(&Persona{}, id).Error
+}
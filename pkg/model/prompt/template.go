@@ -0,0 +1,113 @@
+package prompt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// variablePattern 模板中的变量，如{{diff}}
+var variablePattern = regexp.MustCompile(`\{\{\s*([\p{L}\p{N}_]+)\s*\}\}`)
+
+// Template 提示词模板，Shared为true时所有用户可见，只有创建者和管理员可以修改
+type Template struct {
+	model.BaseModel
+	UserID      uint64 `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	Name        string `gorm:"column:name;type:varchar(64);not null" valid:"name"`
+	Description string `gorm:"column:description;type:varchar(255);not null;default:''" valid:"description"`
+	Content     string `gorm:"column:content;type:text;not null" valid:"content"`
+	// Tags 以英文逗号分隔的标签
+	Tags   string `gorm:"column:tags;type:varchar(255);not null;default:''" valid:"tags"`
+	Shared bool   `gorm:"column:shared;type:bool;not null;default:false" valid:"shared"`
+}
+
+// VisibleTo 用户是否可以使用该模板
+func (t *Template) VisibleTo(userId uint64) bool {
+	return t.Shared || t.UserID == userId
+}
+
+// HasTag 模板是否包含指定标签
+func (t *Template) HasTag(tag string) bool {
+	for _, item := range strings.Split(t.Tags, ",") {
+		if item == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Variables 模板中的变量，按第一次出现的顺序排列
+func (t *Template) Variables() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range variablePattern.FindAllStringSubmatch(t.Content, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// Render 用values替换模板中的变量，缺少变量的值时返回错误
+func (t *Template) Render(values map[string]string) (string, error) {
+	var missing []string
+	for _, name := range t.Variables() {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("缺少变量：%s", strings.Join(missing, "、"))
+	}
+	return variablePattern.ReplaceAllStringFunc(t.Content, func(s string) string {
+		return values[variablePattern.FindStringSubmatch(s)[1]]
+	}), nil
+}
+
+// NormalizeTags 去掉标签的空白及重复项，以英文逗号连接
+func NormalizeTags(tags string) string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, item := range strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == '，' }) {
+		item = strings.TrimSpace(item)
+		if item != "" && !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+// Get 根据ID获取模板
+func Get(id uint64) (template *Template, err error) {
+	template = &Template{}
+	err = model.DB.Where("id = ?", id).First(template).Error
+	return
+}
+
+// List 获取用户自己的及共享的模板
+func List(userId uint64) (templates []*Template, err error) {
+	err = model.DB.Where("user_id = ? OR shared = ?", userId, true).Order("id ASC").Find(&templates).Error
+	return
+}
+
+// Save 创建或更新模板，ID为0时创建
+func Save(template *Template) error {
+	return model.DB.Save(template).Error
+}
+
+// Create 批量添加模板
+func Create(templates []*Template) error {
+	if len(templates) == 0 {
+		return nil
+	}
+	return model.DB.Create(&templates).Error
+}
+
+// Delete 删除模板
+func Delete(id uint64) error {
+	return model.DB.Delete(&Template{}, id).Error
+}
@@ -0,0 +1,58 @@
+package prompt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		values  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"no variables", "review this", nil, "review this", false},
+		{"single variable", "review {{diff}}", map[string]string{"diff": "a.go"}, "review a.go", false},
+		{"spaces inside braces", "{{ lang }}:{{lang}}", map[string]string{"lang": "go"}, "go:go", false},
+		{"chinese name", "翻译成{{语言}}", map[string]string{"语言": "英文"}, "翻译成英文", false},
+		{"value not expanded again", "{{a}}", map[string]string{"a": "{{b}}", "b": "x"}, "{{b}}", false},
+		{"empty value allowed", "[{{a}}]", map[string]string{"a": ""}, "[]", false},
+		{"missing variable", "{{a}} {{b}}", map[string]string{"a": "1"}, "", true},
+		{"not a variable", "{{a-b}}", nil, "{{a-b}}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := &Template{Content: tt.content}
+			got, err := template.Render(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	template := &Template{Content: "{{b}} {{a}} {{ b }} {{c_1}}"}
+	if got, want := template.Variables(), []string{"b", "a", "c_1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables() = %v, want %v", got, want)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"a,b":       "a,b",
+		" a , b ,a": "a,b",
+		"代码，评审,,代码": "代码,评审",
+	}
+	for tags, want := range tests {
+		if got := NormalizeTags(tags); got != want {
+			t.Errorf("NormalizeTags(%q) = %q, want %q", tags, got, want)
+		}
+	}
+}
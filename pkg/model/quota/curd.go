@@ -0,0 +1,195 @@
+package quota
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+)
+
+// SaveBudget 创建或更新预算，同一对象、周期、计量方式只保留一个预算
+func SaveBudget(scope string, scopeId uint64, period string, metric string, limit float64, softLimit float64) (budget *Budget, err error) {
+	budget = &Budget{}
+	err = model.DB.Where("scope = ? AND scope_id = ? AND period = ? AND metric = ?", scope, scopeId, period, metric).
+		Attrs(Budget{
+			PeriodStart: PeriodStartOf(period, time.Now()),
+			ResetAt:     NextReset(period, time.Now()),
+		}).
+		FirstOrInit(budget).Error
+	if err != nil {
+		return
+	}
+	budget.Scope = scope
+	budget.ScopeID = scopeId
+	budget.Period = period
+	budget.Metric = metric
+	budget.Limit = limit
+	budget.SoftLimit = softLimit
+	err = model.DB.Save(budget).Error
+	return
+}
+
+// GetBudget 根据ID获取预算
+func GetBudget(id uint64) (budget *Budget, err error) {
+	budget = &Budget{}
+	err = model.DB.Where("id = ?", id).First(budget).Error
+	return
+}
+
+// DeleteBudget 删除预算及其临时额度
+func DeleteBudget(id uint64) error {
+	err := model.DB.Where("budget_id = ?", id).Delete(&Override{}).Error
+	if err != nil {
+		return err
+	}
+	return model.DB.Delete(&Budget{}, id).Error
+}
+
+// ResetBudget 立即重置预算，从当前时间开始重新计算用量
+func ResetBudget(id uint64) (budget *Budget, err error) {
+	budget, err = GetBudget(id)
+	if err != nil {
+		return
+	}
+	budget.PeriodStart = time.Now()
+	budget.ResetAt = NextReset(budget.Period, budget.PeriodStart)
+	err = model.DB.Save(budget).Error
+	return
+}
+
+// GrantOverride 为预算授予临时额度
+func GrantOverride(budgetId uint64, extra float64, duration time.Duration, reason string, createdBy uint64) (override *Override, err error) {
+	if _, err = GetBudget(budgetId); err != nil {
+		return
+	}
+	override = &Override{
+		BudgetID:  budgetId,
+		Extra:     extra,
+		Reason:    reason,
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(duration),
+	}
+	err = model.DB.Create(override).Error
+	return
+}
+
+// ListStatus 获取预算及使用情况，scope为空表示全部
+func ListStatus(scope string, scopeId uint64) (items []*Status, err error) {
+	var budgets []*Budget
+	query := model.DB.Order("scope ASC, scope_id ASC")
+	if scope != "" {
+		query = query.Where("scope = ? AND scope_id = ?", scope, scopeId)
+	}
+	if err = query.Find(&budgets).Error; err != nil {
+		return
+	}
+	for _, budget := range budgets {
+		status, err := loadStatus(budget)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, status)
+	}
+	return
+}
+
+// Check 调用上游前检查预算，加上本次预估用量后超过额度的返回ExceededError
+func Check(userId uint64, groupId uint64, tokens int, cost float64) error {
+	items, err := userStatus(userId, groupId)
+	if err != nil {
+		return err
+	}
+	for _, status := range items {
+		estimate := float64(tokens)
+		if status.Metric == MetricCost {
+			estimate = cost
+		}
+		if status.Used+estimate > status.Effective() {
+			return &ExceededError{Status: status}
+		}
+	}
+	return nil
+}
+
+// Warnings 用量超过软限制时返回的提示信息
+func Warnings(userId uint64, groupId uint64) (warnings []string, err error) {
+	items, err := userStatus(userId, groupId)
+	if err != nil {
+		return
+	}
+	for _, status := range items {
+		if status.SoftLimit <= 0 || status.Used < status.Effective()*status.SoftLimit {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s已使用%.0f%%（%s/%s），将于%s重置",
+			status.describe(), status.Used/status.Effective()*100, status.format(status.Used),
+			status.format(status.Effective()), status.ResetAt.Format("2006-01-02 15:04")))
+	}
+	return
+}
+
+// ResetExpired 重置已到期的预算，并清理过期的临时额度，由定时任务调用
+func ResetExpired(now time.Time) error {
+	var budgets []*Budget
+	err := model.DB.Where("reset_at <= ?", now).Find(&budgets).Error
+	if err != nil {
+		return err
+	}
+	for _, budget := range budgets {
+		budget.PeriodStart = PeriodStartOf(budget.Period, now)
+		budget.ResetAt = NextReset(budget.Period, now)
+		if err = model.DB.Save(budget).Error; err != nil {
+			return err
+		}
+	}
+	return model.DB.Where("expires_at <= ?", now).Delete(&Override{}).Error
+}
+
+// userStatus 获取用户自身及所在用户组的预算使用情况
+func userStatus(userId uint64, groupId uint64) (items []*Status, err error) {
+	var budgets []*Budget
+	query := model.DB.Where("scope = ? AND scope_id = ?", ScopeUser, userId)
+	if groupId != 0 {
+		query = query.Or("scope = ? AND scope_id = ?", ScopeGroup, groupId)
+	}
+	if err = query.Find(&budgets).Error; err != nil {
+		return
+	}
+	for _, budget := range budgets {
+		status, err := loadStatus(budget)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, status)
+	}
+	return
+}
+
+// loadStatus 统计预算当前周期内的用量和有效临时额度
+func loadStatus(budget *Budget) (*Status, error) {
+	status := &Status{Budget: budget}
+
+	column := "total_tokens"
+	if budget.Metric == MetricCost {
+		column = "cost"
+	}
+	query := model.DB.Model(&usage.Usage{}).Select("COALESCE(SUM("+column+"), 0)").
+		Where("created_at >= ?", budget.PeriodStart)
+	if budget.Scope == ScopeGroup {
+		query = query.Where("user_id IN (?)", model.DB.Table("users").Select("id").Where("group_id = ?", budget.ScopeID))
+	} else {
+		query = query.Where("user_id = ?", budget.ScopeID)
+	}
+	if err := query.Scan(&status.Used).Error; err != nil {
+		return nil, err
+	}
+
+	err := model.DB.Model(&Override{}).Select("COALESCE(SUM(extra), 0)").
+		Where("budget_id = ? AND expires_at > ?", budget.ID, time.Now()).
+		Scan(&status.Extra).Error
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -0,0 +1,108 @@
+package quota
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+	"github.com/869413421/chatgpt-web/pkg/model/usage"
+)
+
+const (
+	// ScopeUser 用户预算
+	ScopeUser = "user"
+	// ScopeGroup 用户组预算，组内所有用户共享
+	ScopeGroup = "group"
+)
+
+const (
+	// MetricTokens 按token数限制
+	MetricTokens = "tokens"
+	// MetricCost 按费用限制
+	MetricCost = "cost"
+)
+
+// Budget 预算，每个周期结束时由定时任务重置
+type Budget struct {
+	model.BaseModel
+	Scope   string  `gorm:"column:scope;type:varchar(16);not null;uniqueIndex:idx_budget" valid:"scope"`
+	ScopeID uint64  `gorm:"column:scope_id;type:bigint(20);not null;uniqueIndex:idx_budget" valid:"scope_id"`
+	Period  string  `gorm:"column:period;type:varchar(16);not null;uniqueIndex:idx_budget" valid:"period"`
+	Metric  string  `gorm:"column:metric;type:varchar(16);not null;uniqueIndex:idx_budget" valid:"metric"`
+	Limit   float64 `gorm:"column:quota_limit;not null" valid:"quota_limit"`
+	// SoftLimit 软限制比例(0~1)，用量超过 Limit*SoftLimit 后在回复中提示，0表示不提示
+	SoftLimit   float64   `gorm:"column:soft_limit;not null;default:0" valid:"soft_limit"`
+	PeriodStart time.Time `gorm:"column:period_start;not null" valid:"period_start"`
+	ResetAt     time.Time `gorm:"column:reset_at;not null;index" valid:"reset_at"`
+}
+
+// Override 管理员授予的临时额度，过期后自动失效
+type Override struct {
+	model.BaseModel
+	BudgetID  uint64    `gorm:"column:budget_id;type:bigint(20);not null;index" valid:"budget_id"`
+	Extra     float64   `gorm:"column:extra;not null" valid:"extra"`
+	Reason    string    `gorm:"column:reason;type:varchar(255);not null;default:''" valid:"reason"`
+	CreatedBy uint64    `gorm:"column:created_by;type:bigint(20);not null" valid:"created_by"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null;index" valid:"expires_at"`
+}
+
+// Status 预算的当前使用情况
+type Status struct {
+	*Budget
+	Used  float64
+	Extra float64 // 有效的临时额度合计
+}
+
+// Effective 实际可用额度
+func (s *Status) Effective() float64 {
+	return s.Limit + s.Extra
+}
+
+// ExceededError 超出预算
+type ExceededError struct {
+	Status *Status
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("已超出%s，已用%s，额度%s，将于%s重置，如需继续使用请联系管理员",
+		e.Status.describe(), e.Status.format(e.Status.Used), e.Status.format(e.Status.Effective()),
+		e.Status.ResetAt.Format("2006-01-02 15:04"))
+}
+
+// describe 预算的中文描述，如“用户每日token预算”
+func (s *Status) describe() string {
+	scope, period, metric := "用户", "每日", "token"
+	if s.Scope == ScopeGroup {
+		scope = "用户组"
+	}
+	if s.Period == usage.PeriodMonth {
+		period = "每月"
+	}
+	if s.Metric == MetricCost {
+		metric = "费用"
+	}
+	return scope + period + metric + "预算"
+}
+
+func (s *Status) format(value float64) string {
+	if s.Metric == MetricCost {
+		return fmt.Sprintf("%.4f", value)
+	}
+	return fmt.Sprintf("%.0f", value)
+}
+
+// NextReset 计算周期的下一次重置时间
+func NextReset(period string, from time.Time) time.Time {
+	if period == usage.PeriodMonth {
+		return time.Date(from.Year(), from.Month()+1, 1, 0, 0, 0, 0, from.Location())
+	}
+	return time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, from.Location())
+}
+
+// PeriodStartOf 计算时间所在周期的开始时间
+func PeriodStartOf(period string, t time.Time) time.Time {
+	if period == usage.PeriodMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
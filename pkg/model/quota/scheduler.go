@@ -0,0 +1,26 @@
+package quota
+
+import (
+	"sync"
+	"time"
+
+	"github.com/869413421/chatgpt-web/pkg/logger"
+)
+
+var schedulerOnce sync.Once
+
+// StartScheduler 启动定时任务，每分钟检查一次需要重置的预算
+func StartScheduler() {
+	schedulerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for {
+				if err := ResetExpired(time.Now()); err != nil {
+					logger.Warning("reset budget error:", err)
+				}
+				<-ticker.C
+			}
+		}()
+	})
+}
@@ -0,0 +1,131 @@
+package usage
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+const (
+	// KindChat 聊天回复
+	KindChat = "chat"
+	// KindSubject 自动生成聊天主题
+	KindSubject = "subject"
+	// KindSummary 总结较早的对话
+	KindSummary = "summary"
+	// KindMemory 从会话中提取长期记忆
+	KindMemory = "memory"
+	// KindApp 使用应用
+	KindApp = "app"
+	// KindEmbedding 计算消息向量
+	KindEmbedding = "embedding"
+)
+
+const (
+	// PeriodDay 按天统计
+	PeriodDay = "day"
+	// PeriodMonth 按月统计
+	PeriodMonth = "month"
+)
+
+// Usage 每次调用上游接口的用量记录
+type Usage struct {
+	model.BaseModel
+	UserID           uint64  `gorm:"column:user_id;type:bigint(20);not null;index" valid:"user_id"`
+	ChatID           string  `gorm:"column:chat_id;type:varchar(255);not null;index" valid:"chat_id"`
+	Kind             string  `gorm:"column:kind;type:varchar(32);not null" valid:"kind"`
+	AppID            uint64  `gorm:"column:app_id;type:bigint(20);not null;default:0;index" valid:"app_id"`
+	Model            string  `gorm:"column:model;type:varchar(255);not null;index" valid:"model"`
+	PromptTokens     int     `gorm:"column:prompt_tokens;not null;default:0" valid:"prompt_tokens"`
+	CompletionTokens int     `gorm:"column:completion_tokens;not null;default:0" valid:"completion_tokens"`
+	TotalTokens      int     `gorm:"column:total_tokens;not null;default:0" valid:"total_tokens"`
+	Latency          int64   `gorm:"column:latency;not null;default:0" valid:"latency"` // 耗时，毫秒
+	Cost             float64 `gorm:"column:cost;not null;default:0" valid:"cost"`       // 按模型单价估算的费用
+	// 冗余日期字段，便于不同数据库统一按天、按月聚合
+	Day   string `gorm:"column:day;type:varchar(10);not null;index" valid:"day"`
+	Month string `gorm:"column:month;type:varchar(7);not null;index" valid:"month"`
+}
+
+// Aggregate 用量聚合结果
+type Aggregate struct {
+	Period           string  `json:"period"`
+	UserID           uint64  `json:"user_id,omitempty"`
+	UserName         string  `json:"user_name,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	AppID            uint64  `json:"app_id,omitempty"`
+	AppName          string  `json:"app_name,omitempty"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	Latency          float64 `json:"latency"` // 平均耗时，毫秒
+}
+
+// Create 记录一次调用用量
+func Create(item *Usage) error {
+	now := time.Now()
+	item.Day = now.Format("2006-01-02")
+	item.Month = now.Format("2006-01")
+	return model.DB.Create(item).Error
+}
+
+// AggregateByUser 按用户统计用量，userId为0时统计全部用户
+// start、end为闭区间，按天统计时格式为2006-01-02，按月统计时格式为2006-01，为空表示不限制
+func AggregateByUser(period string, start string, end string, userId uint64) (items []*Aggregate, err error) {
+	column := periodColumn(period)
+	query := model.DB.Model(&Usage{}).
+		Select(column + " AS period, usages.user_id AS user_id, users.name AS user_name, " + sumColumns).
+		Joins("LEFT JOIN users ON users.id = usages.user_id")
+	query = filterPeriod(query, column, start, end)
+	if userId != 0 {
+		query = query.Where("usages.user_id = ?", userId)
+	}
+	err = query.Group(column + ", usages.user_id, users.name").Order(column + " DESC, total_tokens DESC").Scan(&items).Error
+	return
+}
+
+// AggregateByModel 按模型统计用量
+func AggregateByModel(period string, start string, end string) (items []*Aggregate, err error) {
+	column := periodColumn(period)
+	query := model.DB.Model(&Usage{}).Select(column + " AS period, usages.model AS model, " + sumColumns)
+	query = filterPeriod(query, column, start, end)
+	err = query.Group(column + ", usages.model").Order(column + " DESC, total_tokens DESC").Scan(&items).Error
+	return
+}
+
+// AggregateByApp 按应用统计用量，只统计通过应用发起的调用
+func AggregateByApp(period string, start string, end string) (items []*Aggregate, err error) {
+	column := periodColumn(period)
+	query := model.DB.Model(&Usage{}).
+		Select(column + " AS period, usages.app_id AS app_id, apps.name AS app_name, " + sumColumns).
+		Joins("LEFT JOIN apps ON apps.id = usages.app_id").
+		Where("usages.app_id <> 0")
+	query = filterPeriod(query, column, start, end)
+	err = query.Group(column + ", usages.app_id, apps.name").Order(column + " DESC, total_tokens DESC").Scan(&items).Error
+	return
+}
+
+const sumColumns = "COUNT(*) AS requests, SUM(usages.prompt_tokens) AS prompt_tokens, " +
+	"SUM(usages.completion_tokens) AS completion_tokens, SUM(usages.total_tokens) AS total_tokens, " +
+	"SUM(usages.cost) AS cost, AVG(usages.latency) AS latency"
+
+// periodColumn 统计周期对应的列
+func periodColumn(period string) string {
+	if period == PeriodMonth {
+		return "usages.month"
+	}
+	return "usages.day"
+}
+
+func filterPeriod(query *gorm.DB, column string, start string, end string) *gorm.DB {
+	if start != "" {
+		query = query.Where(column+" >= ?", start)
+	}
+	if end != "" {
+		query = query.Where(column+" <= ?", end)
+	}
+	return query
+}
@@ -0,0 +1,42 @@
+package usage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestAggregateJSON 用量报表的字段名是对外接口，修改会影响已有的调用方
+func TestAggregateJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		item Aggregate
+		want string
+	}{
+		{
+			"by user",
+			Aggregate{Period: "2026-10-19", UserID: 1, UserName: "admin", Requests: 2, PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, Cost: 0.5, Latency: 120},
+			`{"period":"2026-10-19","user_id":1,"user_name":"admin","requests":2,"prompt_tokens":10,"completion_tokens":5,"total_tokens":15,"cost":0.5,"latency":120}`,
+		},
+		{
+			"by model",
+			Aggregate{Period: "2026-10", Model: "gpt-4", Requests: 1},
+			`{"period":"2026-10","model":"gpt-4","requests":1,"prompt_tokens":0,"completion_tokens":0,"total_tokens":0,"cost":0,"latency":0}`,
+		},
+		{
+			"by app",
+			Aggregate{Period: "2026-10", AppID: 3, AppName: "翻译", Requests: 1},
+			`{"period":"2026-10","app_id":3,"app_name":"翻译","requests":1,"prompt_tokens":0,"completion_tokens":0,"total_tokens":0,"cost":0,"latency":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("json = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package user
 
 import (
+	"net/mail"
 	"regexp"
 
 	"github.com/869413421/chatgpt-web/pkg/model"
+	"github.com/869413421/chatgpt-web/pkg/password"
 )
 
 // GetByName 根据名称获取用户
@@ -13,6 +15,20 @@ func GetByName(name string) (user *User, err error) {
 	return
 }
 
+// GetByID 根据ID获取用户
+func GetByID(id uint64) (user *User, err error) {
+	user = &User{}
+	err = model.DB.Where("id = ?", id).First(user).Error
+	return
+}
+
+// GetByEmail 根据邮箱获取用户
+func GetByEmail(email string) (user *User, err error) {
+	user = &User{}
+	err = model.DB.Where("email = ?", email).First(user).Error
+	return
+}
+
 // CreateUser 创建用户
 func CreateUser(name, password string, isadmin bool) (user *User, err error) {
 	user = &User{}
@@ -36,6 +52,45 @@ func UpdatePassword(name string, password string) (user *User, err error) {
 	return
 }
 
+// UpdateEmail 更新邮箱，更新后需要重新验证
+func UpdateEmail(id uint64, email string) (user *User, err error) {
+	user, err = GetByID(id)
+	if err != nil {
+		return
+	}
+	err = model.DB.Model(user).Updates(map[string]interface{}{
+		"email":          email,
+		"email_verified": false,
+	}).Error
+	return
+}
+
+// VerifyEmail 标记邮箱已验证
+func VerifyEmail(id uint64) (user *User, err error) {
+	user, err = GetByID(id)
+	if err != nil {
+		return
+	}
+	err = model.DB.Model(user).Update("email_verified", true).Error
+	return
+}
+
+// SetGroup 设置用户所属用户组，groupId为0表示移出用户组
+func SetGroup(name string, groupId uint64) (user *User, err error) {
+	user, err = GetByName(name)
+	if err != nil {
+		return
+	}
+	err = model.DB.Model(user).Update("group_id", groupId).Error
+	return
+}
+
+// IsEmail 检查邮箱格式是否正确
+func IsEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
 // IsComplexPassword 检查密码是否符合复杂性要求，至少8位，且包含大小写字母、数字
 func IsComplexPassword(_password string) bool {
 	// 定义多个正则表达式来判断密码是否符合要求
@@ -45,5 +100,6 @@ func IsComplexPassword(_password string) bool {
 	number := regexp.MustCompile(`[\d]`).MatchString(_password)
 	// special := regexp.MustCompile(`[$@$!%*#?&]`).MatchString(_password)
 
-	return length >= 8 && upper && lower && number //&& special
+	// bcrypt最多支持72字节
+	return length >= 8 && length <= password.MaxLength && upper && lower && number //&& special
 }
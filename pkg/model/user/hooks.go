@@ -8,7 +8,7 @@ import (
 // BeforeSave 保存前
 func (user *User) BeforeSave(tx *gorm.DB) (err error) {
 	if !password.IsHashed(user.Password) {
-		user.Password = password.Hash(user.Password)
+		user.Password, err = password.Generate(user.Password)
 	}
 	return
 }
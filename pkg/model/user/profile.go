@@ -0,0 +1,60 @@
+package user
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/869413421/chatgpt-web/pkg/model"
+)
+
+// Profile 用户的个人设置
+type Profile struct {
+	model.BaseModel
+	UserID      uint64 `gorm:"column:user_id;type:bigint(20);not null;unique" valid:"user_id"`
+	DisplayName string `gorm:"column:display_name;type:varchar(64);not null;default:''" valid:"display_name"`
+	Avatar      string `gorm:"column:avatar;type:varchar(512);not null;default:''" valid:"avatar"`
+	// Language 界面及回复的首选语言，如zh-CN、en
+	Language string `gorm:"column:language;type:varchar(32);not null;default:''" valid:"language"`
+	// SystemPrompt 默认的系统提示词，不填使用全局的bot_desc
+	SystemPrompt string `gorm:"column:system_prompt;type:text" valid:"system_prompt"`
+	// 界面选项，SendOnEnter未保存过设置时默认开启
+	SendOnEnter bool   `gorm:"column:send_on_enter;type:bool;not null;default:false" valid:"send_on_enter"`
+	Theme       string `gorm:"column:theme;type:varchar(32);not null;default:''" valid:"theme"`
+	// 用户默认的模型及参数
+	model.Params
+}
+
+// GetProfile 获取用户的个人设置，未保存过时返回空设置
+func GetProfile(userId uint64) (profile *Profile, err error) {
+	profile = &Profile{}
+	err = model.DB.Where("user_id = ?", userId).First(profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		profile.UserID = userId
+		profile.SendOnEnter = true
+		err = nil
+	}
+	return
+}
+
+// SaveParams 保存用户默认的模型及参数
+func SaveParams(userId uint64, params model.Params) (profile *Profile, err error) {
+	profile, err = GetProfile(userId)
+	if err != nil {
+		return
+	}
+	profile.Params = params
+	err = model.DB.Save(profile).Error
+	return
+}
+
+// SaveProfile 保存用户的个人设置
+func SaveProfile(profile *Profile) error {
+	current, err := GetProfile(profile.UserID)
+	if err != nil {
+		return err
+	}
+	profile.ID = current.ID
+	profile.CreatedAt = current.CreatedAt
+	return model.DB.Save(profile).Error
+}
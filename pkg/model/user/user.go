@@ -8,9 +8,12 @@ import (
 type User struct {
 	model.BaseModel
 	Name string `gorm:"column:name;type:varchar(255);not null;unique" valid:"name"`
-	//Email    string `gorm:"column:email;type:varchar(255) not null;unique" valid:"email"`
-	Password string `gorm:"column:password;type:varchar(255);not null" valid:"password"`
-	IsAdmin  bool   `gorm:"column:is_admin;type:bool;not null;default:false" valid:"is_admin"`
+	// 邮箱可选，用于找回密码，唯一性由业务代码保证（空值不参与唯一约束）
+	Email         string `gorm:"column:email;type:varchar(255);not null;default:'';index" valid:"email"`
+	EmailVerified bool   `gorm:"column:email_verified;type:bool;not null;default:false" valid:"email_verified"`
+	Password      string `gorm:"column:password;type:varchar(255);not null" valid:"password"`
+	IsAdmin       bool   `gorm:"column:is_admin;type:bool;not null;default:false" valid:"is_admin"`
+	GroupID       uint64 `gorm:"column:group_id;type:bigint(20);not null;default:0;index" valid:"group_id"`
 	// gorm:"-" 使用这个注解GORM读写会忽略这个字段
 	//PasswordComfirm string `gorm:"-" valid:"password_comfirm"`
 }
@@ -8,14 +8,26 @@ import (
 	"github.com/869413421/chatgpt-web/pkg/logger"
 )
 
+// MaxLength bcrypt支持的密码最大字节数，超出时加密会报错
+const MaxLength = 72
+
 // Hash 进行加密
 func Hash(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	hash, err := Generate(password)
 	if err != nil {
 		logger.Danger(err, "hash password error")
 	}
 
-	return string(bytes)
+	return hash
+}
+
+// Generate 进行加密，出错时返回错误，用于加密用户输入的密码
+func Generate(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
 }
 
 //CheckHash 检查密码和hash是否匹配
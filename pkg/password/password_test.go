@@ -0,0 +1,30 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRejectsLongPassword(t *testing.T) {
+	// 25个汉字为75字节，超过bcrypt的72字节限制
+	long := strings.Repeat("密", 25)
+	if _, err := Generate(long); err == nil {
+		t.Fatalf("Generate(%d bytes) returned no error", len(long))
+	}
+}
+
+func TestGenerateAndCheck(t *testing.T) {
+	hash, err := Generate("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHashed(hash) {
+		t.Errorf("IsHashed(%q) = false", hash)
+	}
+	if !CheckHash("secret", hash) {
+		t.Error("CheckHash rejected the right password")
+	}
+	if CheckHash("wrong", hash) {
+		t.Error("CheckHash accepted a wrong password")
+	}
+}
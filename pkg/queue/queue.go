@@ -0,0 +1,243 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrTimeout 排队超时
+var ErrTimeout = errors.New("当前请求较多，排队等待超时，请稍后再试")
+
+// Pool 上游并发池，超过并发上限的请求进入队列
+// 优先队列（管理员）最先出队，其余请求按用户轮转出队，避免单个用户的大量请求占满队列
+type Pool struct {
+	mu       sync.Mutex
+	capacity int
+	running  int
+	priority []*waiter
+	queues   map[uint64][]*waiter
+	users    []uint64 // 有排队请求的用户，按轮转顺序排列
+}
+
+type waiter struct {
+	userID   uint64
+	priority bool
+	granted  bool
+	ready    chan struct{}
+	position chan int // 只保留最新的排队位置
+	notified int      // 最近一次通知的排队位置
+}
+
+// NewPool 创建并发池，capacity不大于0表示不限制并发
+func NewPool(capacity int) *Pool {
+	return &Pool{capacity: capacity, queues: make(map[uint64][]*waiter)}
+}
+
+// SetCapacity 修改并发上限
+func (p *Pool) SetCapacity(capacity int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.capacity == capacity {
+		return
+	}
+	p.capacity = capacity
+	p.dispatch()
+}
+
+// Acquire 获取一个并发名额，返回的release必须在调用结束后执行
+// 排队期间排队位置变化时调用onPosition（从1开始），ctx取消或超时后放弃排队
+func (p *Pool) Acquire(ctx context.Context, userID uint64, priority bool, onPosition func(position int)) (release func(), err error) {
+	p.mu.Lock()
+	if p.capacity <= 0 || (p.running < p.capacity && p.waiting() == 0) {
+		p.running++
+		p.mu.Unlock()
+		return p.releaseFunc(), nil
+	}
+
+	w := &waiter{userID: userID, priority: priority, ready: make(chan struct{}), position: make(chan int, 1)}
+	p.enqueue(w)
+	p.notifyPositions()
+	p.mu.Unlock()
+
+	for {
+		select {
+		case <-w.ready:
+			return p.releaseFunc(), nil
+		case position := <-w.position:
+			if onPosition != nil {
+				onPosition(position)
+			}
+		case <-ctx.Done():
+			p.mu.Lock()
+			if w.granted {
+				p.mu.Unlock()
+				return p.releaseFunc(), nil
+			}
+			p.remove(w)
+			p.notifyPositions()
+			p.mu.Unlock()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats 当前运行中和排队中的请求数
+func (p *Pool) Stats() (running int, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running, p.waiting()
+}
+
+func (p *Pool) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.running--
+			p.dispatch()
+		})
+	}
+}
+
+// dispatch 在有空闲名额时按顺序唤醒排队的请求
+func (p *Pool) dispatch() {
+	changed := false
+	for p.capacity <= 0 || p.running < p.capacity {
+		w := p.next()
+		if w == nil {
+			break
+		}
+		w.granted = true
+		p.running++
+		close(w.ready)
+		changed = true
+	}
+	if changed {
+		p.notifyPositions()
+	}
+}
+
+func (p *Pool) waiting() int {
+	count := len(p.priority)
+	for _, q := range p.queues {
+		count += len(q)
+	}
+	return count
+}
+
+func (p *Pool) enqueue(w *waiter) {
+	if w.priority {
+		p.priority = append(p.priority, w)
+		return
+	}
+	if len(p.queues[w.userID]) == 0 {
+		p.users = append(p.users, w.userID)
+	}
+	p.queues[w.userID] = append(p.queues[w.userID], w)
+}
+
+// next 取出下一个请求
+func (p *Pool) next() *waiter {
+	if len(p.priority) > 0 {
+		w := p.priority[0]
+		p.priority = p.priority[1:]
+		return w
+	}
+	if len(p.users) == 0 {
+		return nil
+	}
+	userID := p.users[0]
+	p.users = p.users[1:]
+	q := p.queues[userID]
+	w := q[0]
+	if len(q) > 1 {
+		p.queues[userID] = q[1:]
+		p.users = append(p.users, userID)
+	} else {
+		delete(p.queues, userID)
+	}
+	return w
+}
+
+func (p *Pool) remove(w *waiter) {
+	if w.priority {
+		p.priority = removeWaiter(p.priority, w)
+		return
+	}
+	q := removeWaiter(p.queues[w.userID], w)
+	if len(q) > 0 {
+		p.queues[w.userID] = q
+		return
+	}
+	delete(p.queues, w.userID)
+	for i, userID := range p.users {
+		if userID == w.userID {
+			p.users = append(p.users[:i], p.users[i+1:]...)
+			break
+		}
+	}
+}
+
+// order 按出队顺序排列所有排队中的请求
+func (p *Pool) order() []*waiter {
+	result := append([]*waiter{}, p.priority...)
+	for round := 0; ; round++ {
+		added := false
+		for _, userID := range p.users {
+			if q := p.queues[userID]; round < len(q) {
+				result = append(result, q[round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return result
+}
+
+// notifyPositions 通知所有排队请求最新的排队位置
+func (p *Pool) notifyPositions() {
+	for i, w := range p.order() {
+		if w.notified == i+1 {
+			continue
+		}
+		w.notified = i + 1
+		select {
+		case <-w.position:
+		default:
+		}
+		w.position <- i + 1
+	}
+}
+
+func removeWaiter(waiters []*waiter, w *waiter) []*waiter {
+	for i, item := range waiters {
+		if item == w {
+			return append(waiters[:i], waiters[i+1:]...)
+		}
+	}
+	return waiters
+}
+
+var pools = make(map[string]*Pool)
+var poolsMu sync.Mutex
+
+// Get 获取key（上游地址及密钥）对应的并发池，并同步最新的并发上限
+func Get(key string, capacity int) *Pool {
+	poolsMu.Lock()
+	pool, ok := pools[key]
+	if !ok {
+		pool = NewPool(capacity)
+		pools[key] = pool
+	}
+	poolsMu.Unlock()
+
+	pool.SetCapacity(capacity)
+	return pool
+}
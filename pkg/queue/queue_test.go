@@ -0,0 +1,288 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued 等待排队中的请求数达到n
+func waitQueued(t *testing.T, p *Pool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, waiting := p.Stats(); waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiting requests did not reach %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queued 测试中排队的请求
+type queued struct {
+	name     string
+	userID   uint64
+	priority bool
+}
+
+// acquireOrder 依次让waiters排队，释放占用的名额后返回获得名额的顺序
+// 每个请求获得名额后立即释放，唤醒下一个
+func acquireOrder(t *testing.T, p *Pool, hold func(), waiters []queued) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, w := range waiters {
+		wg.Add(1)
+		go func(name string, userID uint64, priority bool) {
+			defer wg.Done()
+			release, err := p.Acquire(context.Background(), userID, priority, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			release()
+		}(w.name, w.userID, w.priority)
+		waitQueued(t, p, i+1)
+	}
+	hold()
+	wg.Wait()
+	return order
+}
+
+func TestPoolOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		waiters []queued
+		want    []string
+	}{
+		{
+			"round robin between users",
+			[]queued{{"a1", 1, false}, {"a2", 1, false}, {"a3", 1, false}, {"b1", 2, false}, {"b2", 2, false}},
+			[]string{"a1", "b1", "a2", "b2", "a3"},
+		},
+		{
+			"priority first",
+			[]queued{{"a1", 1, false}, {"b1", 2, false}, {"admin1", 3, true}, {"admin2", 3, true}},
+			[]string{"admin1", "admin2", "a1", "b1"},
+		},
+		{
+			"same user in order",
+			[]queued{{"a1", 1, false}, {"a2", 1, false}, {"a3", 1, false}},
+			[]string{"a1", "a2", "a3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPool(1)
+			hold, err := p.Acquire(context.Background(), 0, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			order := acquireOrder(t, p, hold, tt.waiters)
+			if len(order) != len(tt.want) {
+				t.Fatalf("order = %v, want %v", order, tt.want)
+			}
+			for i := range order {
+				if order[i] != tt.want[i] {
+					t.Fatalf("order = %v, want %v", order, tt.want)
+				}
+			}
+			if running, waiting := p.Stats(); running != 0 || waiting != 0 {
+				t.Errorf("after all released running = %d, waiting = %d", running, waiting)
+			}
+		})
+	}
+}
+
+func TestPoolUnlimited(t *testing.T) {
+	p := NewPool(0)
+	var releases []func()
+	for i := 0; i < 10; i++ {
+		release, err := p.Acquire(context.Background(), 1, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	if running, waiting := p.Stats(); running != 10 || waiting != 0 {
+		t.Errorf("running = %d, waiting = %d, want 10 and 0", running, waiting)
+	}
+	for _, release := range releases {
+		release()
+		// 重复释放不影响计数
+		release()
+	}
+	if running, _ := p.Stats(); running != 0 {
+		t.Errorf("running after release = %d", running)
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	p := NewPool(1)
+	hold, err := p.Acquire(context.Background(), 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = p.Acquire(ctx, 2, false, nil); err != ErrTimeout {
+		t.Fatalf("Acquire() error = %v, want ErrTimeout", err)
+	}
+	if running, waiting := p.Stats(); running != 1 || waiting != 0 {
+		t.Errorf("after timeout running = %d, waiting = %d, want 1 and 0", running, waiting)
+	}
+	hold()
+	if running, _ := p.Stats(); running != 0 {
+		t.Errorf("running after release = %d, want 0", running)
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	p := NewPool(1)
+	hold, err := p.Acquire(context.Background(), 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := p.Acquire(ctx, 2, false, nil)
+		done <- err
+	}()
+	waitQueued(t, p, 1)
+	cancel()
+	if err = <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire() error = %v, want context.Canceled", err)
+	}
+	hold()
+	// 取消的请求不占用名额，之后的请求可以立即获得
+	release, err := p.Acquire(context.Background(), 3, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running, waiting := p.Stats(); running != 1 || waiting != 0 {
+		t.Errorf("running = %d, waiting = %d, want 1 and 0", running, waiting)
+	}
+	release()
+}
+
+// TestPoolCancelRace 取消与获得名额同时发生时不能丢失名额，需配合-race运行
+func TestPoolCancelRace(t *testing.T) {
+	p := NewPool(2)
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%5)*time.Millisecond)
+			defer cancel()
+			release, err := p.Acquire(ctx, uint64(i%7), i%11 == 0, nil)
+			if err != nil {
+				if err != ErrTimeout && !errors.Is(err, context.Canceled) {
+					t.Error(err)
+				}
+				return
+			}
+			time.Sleep(time.Duration(i%3) * time.Millisecond)
+			release()
+		}(i)
+	}
+	wg.Wait()
+	if running, waiting := p.Stats(); running != 0 || waiting != 0 {
+		t.Errorf("running = %d, waiting = %d, want 0 and 0", running, waiting)
+	}
+}
+
+func TestPoolPosition(t *testing.T) {
+	p := NewPool(1)
+	hold, err := p.Acquire(context.Background(), 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make(chan struct{})
+	go func() {
+		release, err := p.Acquire(context.Background(), 2, false, nil)
+		if err == nil {
+			<-first
+			release()
+		}
+	}()
+	waitQueued(t, p, 1)
+
+	var mu sync.Mutex
+	var positions []int
+	done := make(chan struct{})
+	go func() {
+		release, err := p.Acquire(context.Background(), 3, false, func(position int) {
+			mu.Lock()
+			positions = append(positions, position)
+			mu.Unlock()
+		})
+		if err == nil {
+			release()
+		}
+		close(done)
+	}()
+	waitQueued(t, p, 2)
+	// 只保留最新的排队位置，等收到第一次通知后再让排队位置变化
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		notified := len(positions)
+		mu.Unlock()
+		if notified > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("position was not notified")
+		}
+	}
+	hold()
+	waitQueued(t, p, 1)
+	close(first)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(positions) != 2 || positions[0] != 2 || positions[1] != 1 {
+		t.Errorf("positions = %v, want [2 1]", positions)
+	}
+}
+
+func TestPoolSetCapacity(t *testing.T) {
+	p := NewPool(1)
+	hold, err := p.Acquire(context.Background(), 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan func())
+	for i := 0; i < 2; i++ {
+		go func(userID uint64) {
+			release, err := p.Acquire(context.Background(), userID, false, nil)
+			if err == nil {
+				acquired <- release
+			}
+		}(uint64(i + 2))
+		waitQueued(t, p, i+1)
+	}
+	// 提高上限后排队的请求立即获得名额
+	p.SetCapacity(3)
+	releases := []func(){<-acquired, <-acquired, hold}
+	if running, waiting := p.Stats(); running != 3 || waiting != 0 {
+		t.Errorf("running = %d, waiting = %d, want 3 and 0", running, waiting)
+	}
+	for _, release := range releases {
+		release()
+	}
+	if running, _ := p.Stats(); running != 0 {
+		t.Errorf("running after release = %d, want 0", running)
+	}
+}
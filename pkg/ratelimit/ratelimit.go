@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit 令牌桶参数
+type Limit struct {
+	Rate  float64 // 每秒补充的令牌数
+	Burst int     // 桶容量，即允许的突发请求数
+}
+
+// PerMinute 按每分钟请求数创建令牌桶参数，burst不大于0时等于每分钟请求数
+func PerMinute(requests float64, burst int) Limit {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(requests)))
+	}
+	return Limit{Rate: requests / 60, Burst: burst}
+}
+
+// Enabled 是否开启限流
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// Result 取令牌的结果
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // 被限流时，距离下一个可用令牌的等待时间
+}
+
+// Store 令牌桶存储，默认使用进程内存，多实例部署时可实现共享存储（如Redis）后通过SetStore替换
+type Store interface {
+	Take(key string, limit Limit) (Result, error)
+}
+
+// LimitedError 请求被限流
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("请求过于频繁，请%d秒后再试", e.Seconds())
+}
+
+// Seconds 需要等待的秒数，向上取整
+func (e *LimitedError) Seconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+var store Store = NewMemoryStore()
+
+// SetStore 替换令牌桶存储
+func SetStore(s Store) {
+	store = s
+}
+
+// Take 从key对应的令牌桶中取一个令牌，被限流时返回LimitedError
+func Take(key string, limit Limit) error {
+	if !limit.Enabled() {
+		return nil
+	}
+	result, err := store.Take(key, limit)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed {
+		return &LimitedError{RetryAfter: result.RetryAfter}
+	}
+	return nil
+}
+
+// MemoryStore 进程内存令牌桶存储
+type MemoryStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+	now         func() time.Time // 当前时间，测试时可替换
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // 令牌补满的时间，之后可以回收
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Take 取一个令牌
+func (s *MemoryStore) Take(key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.cleanup(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+		b.last = now
+	}
+
+	result := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+		result.Remaining = int(b.tokens)
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second)))
+	return result, nil
+}
+
+// cleanup 每分钟回收一次已补满的令牌桶，避免key无限增长
+func (s *MemoryStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < time.Minute {
+		return
+	}
+	s.lastCleanup = now
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
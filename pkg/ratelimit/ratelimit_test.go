@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 测试用的时钟，只在调用Add时前进
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestPerMinute(t *testing.T) {
+	tests := []struct {
+		requests float64
+		burst    int
+		want     Limit
+	}{
+		{60, 0, Limit{Rate: 1, Burst: 60}},
+		{30, 5, Limit{Rate: 0.5, Burst: 5}},
+		{0.5, 0, Limit{Rate: 0.5 / 60, Burst: 1}},
+		{0, 0, Limit{Rate: 0, Burst: 1}},
+	}
+	for _, tt := range tests {
+		if got := PerMinute(tt.requests, tt.burst); got != tt.want {
+			t.Errorf("PerMinute(%v, %d) = %+v, want %+v", tt.requests, tt.burst, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	// 每分钟6次，即10秒补充1个令牌，最多连续3次
+	limit := PerMinute(6, 3)
+	type step struct {
+		wait       time.Duration // 取令牌前经过的时间
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then limited", []step{
+			{0, true, 2, 0},
+			{0, true, 1, 0},
+			{0, true, 0, 0},
+			{0, false, 0, 10 * time.Second},
+		}},
+		{"retry after shrinks", []step{
+			{0, true, 2, 0}, {0, true, 1, 0}, {0, true, 0, 0},
+			{4 * time.Second, false, 0, 6 * time.Second},
+			{6 * time.Second, true, 0, 0},
+		}},
+		{"refill one token", []step{
+			{0, true, 2, 0}, {0, true, 1, 0}, {0, true, 0, 0},
+			{10 * time.Second, true, 0, 0},
+			{0, false, 0, 10 * time.Second},
+		}},
+		{"refill capped at burst", []step{
+			{0, true, 2, 0},
+			{time.Hour, true, 2, 0},
+			{0, true, 1, 0},
+			{0, true, 0, 0},
+			{0, false, 0, 10 * time.Second},
+		}},
+		{"limited requests do not consume", []step{
+			{0, true, 2, 0}, {0, true, 1, 0}, {0, true, 0, 0},
+			{0, false, 0, 10 * time.Second},
+			{0, false, 0, 10 * time.Second},
+			{10 * time.Second, true, 0, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, clock := newTestStore()
+			for i, step := range tt.steps {
+				clock.Add(step.wait)
+				result, err := store.Take("user:1", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 令牌按浮点数计算，等待时间精确到毫秒比较
+				result.RetryAfter = result.RetryAfter.Round(time.Millisecond)
+				want := Result{Allowed: step.allowed, Remaining: step.remaining, RetryAfter: step.retryAfter}
+				if result != want {
+					t.Fatalf("step %d: Take() = %+v, want %+v", i, result, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	store, _ := newTestStore()
+	limit := PerMinute(1, 1)
+	if result, _ := store.Take("user:1", limit); !result.Allowed {
+		t.Fatal("first request of user:1 limited")
+	}
+	if result, _ := store.Take("user:1", limit); result.Allowed {
+		t.Fatal("second request of user:1 allowed")
+	}
+	// 不同key的令牌桶相互独立
+	if result, _ := store.Take("user:2", limit); !result.Allowed {
+		t.Error("request of user:2 limited by user:1")
+	}
+	if result, _ := store.Take("ip:1", PerMinute(60, 0)); !result.Allowed || result.Remaining != 59 {
+		t.Errorf("request of ip:1 = %+v, want allowed with 59 remaining", result)
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	store, clock := newTestStore()
+	limit := PerMinute(60, 0)
+	store.Take("user:1", limit)
+	store.Take("user:2", limit)
+	// 令牌补满一分钟后回收，回收后重新以满桶开始
+	clock.Add(2 * time.Minute)
+	result, _ := store.Take("user:3", limit)
+	if len(store.buckets) != 1 {
+		t.Errorf("buckets after cleanup = %d, want 1", len(store.buckets))
+	}
+	if !result.Allowed || result.Remaining != 59 {
+		t.Errorf("Take() = %+v, want allowed with 59 remaining", result)
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	store, _ := newTestStore()
+	limit := PerMinute(60, 50)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := store.Take("shared", limit)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// 时钟不前进，并发请求中只有burst个能取到令牌
+	if allowed != 50 {
+		t.Errorf("allowed = %d, want 50", allowed)
+	}
+}
+
+func TestTake(t *testing.T) {
+	store, _ := newTestStore()
+	SetStore(store)
+	defer SetStore(NewMemoryStore())
+
+	if err := Take("model:gpt-4", Limit{}); err != nil {
+		t.Errorf("Take() with disabled limit error = %v", err)
+	}
+	limit := PerMinute(2, 1)
+	if err := Take("model:gpt-4", limit); err != nil {
+		t.Fatalf("first Take() error = %v", err)
+	}
+	err := Take("model:gpt-4", limit)
+	limited, ok := err.(*LimitedError)
+	if !ok {
+		t.Fatalf("second Take() error = %v, want LimitedError", err)
+	}
+	if limited.Seconds() != 30 {
+		t.Errorf("Seconds() = %d, want 30", limited.Seconds())
+	}
+}
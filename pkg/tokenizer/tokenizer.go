@@ -0,0 +1,114 @@
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	gogpt "github.com/sashabaranov/go-openai"
+
+	"github.com/869413421/chatgpt-web/pkg/logger"
+)
+
+// defaultEncoding 无法按模型名称确定编码时使用，gpt-3.5、gpt-4以及大多数兼容接口的模型按此计算
+const defaultEncoding = tiktoken.MODEL_CL100K_BASE
+
+// modelPrefixes tiktoken-go中缺少的模型前缀
+var modelPrefixes = map[string]string{
+	"o1":          tiktoken.MODEL_O200K_BASE,
+	"o3":          tiktoken.MODEL_O200K_BASE,
+	"o4":          tiktoken.MODEL_O200K_BASE,
+	"chatgpt-4o-": tiktoken.MODEL_O200K_BASE,
+}
+
+var (
+	encoders   = make(map[string]*tiktoken.Tiktoken)
+	encodersMu sync.Mutex
+)
+
+func init() {
+	// 使用随程序编译的词表，不在运行时下载
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// Encoding 按模型名称选择BPE编码，gpt-4o等新模型使用o200k_base，其余使用cl100k_base
+func Encoding(model string) string {
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encoding
+	}
+	// 取最长的前缀，避免gpt-4-和gpt-4o-这类前缀的匹配结果依赖map的遍历顺序
+	encoding, matched := defaultEncoding, 0
+	for _, prefixes := range []map[string]string{tiktoken.MODEL_PREFIX_TO_ENCODING, modelPrefixes} {
+		for prefix, name := range prefixes {
+			if strings.HasPrefix(model, prefix) && len(prefix) > matched {
+				encoding, matched = name, len(prefix)
+			}
+		}
+	}
+	return encoding
+}
+
+// encoder 获取编码器，词表较大，首次使用时加载并缓存
+func encoder(encoding string) *tiktoken.Tiktoken {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if enc, ok := encoders[encoding]; ok {
+		return enc
+	}
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		logger.Warning("load token encoding", encoding, "error:", err)
+	}
+	// 加载失败时缓存nil，按字符估算，不重复加载
+	encoders[encoding] = enc
+	return enc
+}
+
+// Count 计算文本在模型编码下的token数
+func Count(model string, text string) int {
+	if text == "" {
+		return 0
+	}
+	enc := encoder(Encoding(model))
+	if enc == nil {
+		return estimate(text)
+	}
+	// 不把文本中的<|endoftext|>等当作特殊token，按普通文本计算
+	return len(enc.Encode(text, nil, nil))
+}
+
+// estimate 编码器不可用时按字符估算token数，结果偏大，避免超出上下文窗口
+// ASCII字符通常3到4个组成一个token，按每2个1个token计算；中文等字符可能被拆成多个token，按每个2个token计算
+func estimate(text string) int {
+	ascii, others := 0, 0
+	for _, r := range text {
+		switch {
+		case r > unicode.MaxASCII:
+			others++
+		case !unicode.IsSpace(r):
+			ascii++
+		}
+	}
+	return (ascii+1)/2 + others*2
+}
+
+// CountMessages 计算对话消息的token数，包含每条消息的格式开销
+func CountMessages(model string, messages []gogpt.ChatCompletionMessage) int {
+	// 参考OpenAI的计算方式：每条消息额外3个token，回复前缀额外3个token
+	tokens := 3
+	for _, message := range messages {
+		tokens += CountMessage(model, message)
+	}
+	return tokens
+}
+
+// CountMessage 计算单条消息的token数，包含消息的格式开销
+func CountMessage(model string, message gogpt.ChatCompletionMessage) int {
+	tokens := 3 + Count(model, message.Role) + Count(model, message.Content)
+	if message.Name != "" {
+		tokens += 1 + Count(model, message.Name)
+	}
+	return tokens
+}
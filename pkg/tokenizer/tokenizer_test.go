@@ -0,0 +1,85 @@
+package tokenizer
+
+import (
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-3.5-turbo", "cl100k_base"},
+		{"gpt-4-0613", "cl100k_base"},
+		{"gpt-4o", "o200k_base"},
+		{"gpt-4o-mini", "o200k_base"},
+		{"gpt-4o-2024-05-13", "o200k_base"},
+		{"gpt-4.1-mini", "o200k_base"},
+		{"o3-mini", "o200k_base"},
+		{"text-embedding-3-small", "cl100k_base"},
+		{"qwen-max", "cl100k_base"},
+		{"", "cl100k_base"},
+	}
+	for _, tt := range tests {
+		if got := Encoding(tt.model); got != tt.want {
+			t.Errorf("Encoding(%q) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		model string
+		text  string
+		want  int
+	}{
+		{"gpt-4", "", 0},
+		{"gpt-4", "hello world", 2},
+		{"gpt-4", "tiktoken is great!", 6},
+		{"gpt-4", "你好，世界", 6},
+		{"gpt-4o", "你好，世界", 3},
+		{"gpt-4", "func main() {\n\tfmt.Println(\"hi\")\n}", 10},
+		// 特殊token按普通文本计算
+		{"gpt-4", "<|endoftext|>", 7},
+	}
+	for _, tt := range tests {
+		if got := Count(tt.model, tt.text); got != tt.want {
+			t.Errorf("Count(%s, %q) = %d, want %d", tt.model, tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestCountMessages 与OpenAI cookbook中计算对话token数的示例结果一致
+func TestCountMessages(t *testing.T) {
+	messages := []gogpt.ChatCompletionMessage{
+		{Role: "system", Content: "You are a helpful, pattern-following assistant that translates corporate jargon into plain English."},
+		{Role: "system", Name: "example_user", Content: "New synergies will help drive top-line growth."},
+		{Role: "system", Name: "example_assistant", Content: "Things working well together will increase revenue."},
+		{Role: "system", Name: "example_user", Content: "Let's circle back when we have more time to touch base on opportunities for increased leverage."},
+		{Role: "system", Name: "example_assistant", Content: "Let's talk later when we're less busy about how to do better."},
+		{Role: "user", Content: "This late pivot means we don't have time to boil the ocean for the client deliverable."},
+	}
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4-0613", 129},
+		{"gpt-4o", 124},
+	}
+	for _, tt := range tests {
+		if got := CountMessages(tt.model, messages); got != tt.want {
+			t.Errorf("CountMessages(%s) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	// 编码器不可用时的估算不能少于实际的token数
+	for _, text := range []string{"hello world", "tiktoken is great!", "你好，世界", "func main() {\n\tfmt.Println(\"hi\")\n}"} {
+		if got, actual := estimate(text), Count("gpt-4", text); got < actual {
+			t.Errorf("estimate(%q) = %d, less than %d", text, got, actual)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// currentTime 获取当前时间
+type currentTime struct{}
+
+func (currentTime) Definition() gogpt.FunctionDefinition {
+	return gogpt.FunctionDefinition{
+		Name:        "current_time",
+		Description: "Get the current date and time of the server",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+	}
+}
+
+func (currentTime) Call(context.Context, string) (string, error) {
+	return time.Now().Format("2006-01-02 15:04:05 Monday MST"), nil
+}
+
+// calculator 计算四则运算表达式，避免模型心算出错
+type calculator struct{}
+
+func (calculator) Definition() gogpt.FunctionDefinition {
+	return gogpt.FunctionDefinition{
+		Name:        "calculator",
+		Description: "Evaluate an arithmetic expression with + - * / % and parentheses",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"e.g. (1+2)*3/4"}},"required":["expression"]}`),
+	}
+}
+
+func (calculator) Call(_ context.Context, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+	p := &exprParser{input: args.Expression}
+	value, err := p.parseExpr()
+	if err != nil {
+		return "", err
+	}
+	if p.peek() != 0 {
+		return "", fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos)
+	}
+	// 加0把-0转为0
+	return strconv.FormatFloat(value+0, 'g', -1, 64), nil
+}
+
+// exprParser 四则运算的递归下降解析
+type exprParser struct {
+	input string
+	pos   int
+}
+
+// peek 跳过空白后返回下一个字符，数字之间的空白不会被忽略，如"1 2"不会被当作12
+func (p *exprParser) peek() byte {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+// parseExpr expr = term {("+"|"-") term}
+func (p *exprParser) parseExpr() (float64, error) {
+	value, err := p.parseTerm()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.peek()
+		p.pos++
+		var right float64
+		if right, err = p.parseTerm(); err != nil {
+			break
+		}
+		if op == '+' {
+			value, err = checkOverflow(value + right)
+		} else {
+			value, err = checkOverflow(value - right)
+		}
+	}
+	return value, err
+}
+
+// parseTerm term = factor {("*"|"/"|"%") factor}
+func (p *exprParser) parseTerm() (float64, error) {
+	value, err := p.parseFactor()
+	for err == nil && (p.peek() == '*' || p.peek() == '/' || p.peek() == '%') {
+		op := p.peek()
+		p.pos++
+		var right float64
+		if right, err = p.parseFactor(); err != nil {
+			break
+		}
+		switch {
+		case op == '*':
+			value, err = checkOverflow(value * right)
+		case right == 0:
+			return 0, fmt.Errorf("division by zero")
+		case op == '/':
+			value, err = checkOverflow(value / right)
+		default:
+			// 按浮点数取余，不转换为int64，避免超出范围的值被截断或溢出
+			value = math.Mod(value, right)
+		}
+	}
+	return value, err
+}
+
+// checkOverflow 计算结果超出浮点数范围时返回错误，避免返回Inf或NaN
+func checkOverflow(value float64) (float64, error) {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("result out of range")
+	}
+	return value, nil
+}
+
+// parseFactor factor = ["-"] (number | "(" expr ")")
+func (p *exprParser) parseFactor() (float64, error) {
+	if p.peek() == '-' {
+		p.pos++
+		value, err := p.parseFactor()
+		return -value, err
+	}
+	if p.peek() == '(' {
+		p.pos++
+		value, err := p.parseExpr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return value, nil
+	}
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '.' || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("expected number at %d", start)
+	}
+	return strconv.ParseFloat(p.input[start:p.pos], 64)
+}
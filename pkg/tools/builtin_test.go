@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestCalculator(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+		wantErr    bool
+	}{
+		{"1+2", "3", false},
+		{"(2+3)*4", "20", false},
+		{"2+3*4", "14", false},
+		{"10/4", "2.5", false},
+		{"7%3", "1", false},
+		{"-3+5", "2", false},
+		{"-(1+2)*2", "-6", false},
+		{" 1 + 2 ", "3", false},
+		{"( 1 + 2 ) * 3\n", "9", false},
+		{"0.1*3", "0.30000000000000004", false},
+		{"1/0", "", true},
+		{"5%0", "", true},
+		{"5%0.5", "0", false},
+		{"7.5%2", "1.5", false},
+		{"-7%3", "-1", false},
+		// 超出int64范围的取余不溢出
+		{"-9223372036854775808%-1", "0", false},
+		{"100000000000000000000%7", "2", false},
+		{"-0", "0", false},
+		// 超出浮点数范围时返回错误
+		{"99999999999999999999999999999999999999999999999999*99999999999999999999999999999999999999999999999999" +
+			"*99999999999999999999999999999999999999999999999999*99999999999999999999999999999999999999999999999999" +
+			"*99999999999999999999999999999999999999999999999999*99999999999999999999999999999999999999999999999999" +
+			"*99999999999999999999999999999999999999999999999999", "", true},
+		{"(1+2", "", true},
+		{"1+", "", true},
+		{"2*x", "", true},
+		{"1 2", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		arguments, _ := json.Marshal(map[string]string{"expression": tt.expression})
+		got, err := calculator{}.Call(context.Background(), string(arguments))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("calculator(%q) error = %v, wantErr %v", tt.expression, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("calculator(%q) = %q, want %q", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		want      string
+	}{
+		{"calculator", `{"expression":"6*7"}`, "42"},
+		{"calculator", `not json`, "error: invalid character 'o' in literal null (expecting 'u')"},
+		{"missing", `{}`, "error: unknown tool missing"},
+	}
+	for _, tt := range tests {
+		got := Call(context.Background(), gogpt.ToolCall{Function: gogpt.FunctionCall{Name: tt.name, Arguments: tt.arguments}})
+		if got != tt.want {
+			t.Errorf("Call(%s, %s) = %q, want %q", tt.name, tt.arguments, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// Tool 可由模型调用的工具
+type Tool interface {
+	// Definition 提供给模型的函数定义，Name需要与注册的名称一致
+	Definition() gogpt.FunctionDefinition
+	// Call 以模型给出的JSON参数执行工具，返回结果作为tool消息发送给模型
+	Call(ctx context.Context, arguments string) (string, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Tool)
+)
+
+// Register 注册工具，同名工具会被覆盖
+func Register(tool Tool) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[tool.Definition().Name] = tool
+}
+
+// Get 获取已注册的工具
+func Get(name string) (Tool, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	tool, ok := registry[name]
+	return tool, ok
+}
+
+// Names 全部已注册工具的名称，按名称排序
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Definitions 指定工具在请求中的定义，工具不存在时返回错误
+func Definitions(names []string) ([]gogpt.Tool, error) {
+	result := make([]gogpt.Tool, 0, len(names))
+	for _, name := range names {
+		tool, ok := Get(name)
+		if !ok {
+			return nil, fmt.Errorf("工具不存在：%s", name)
+		}
+		definition := tool.Definition()
+		result = append(result, gogpt.Tool{Type: gogpt.ToolTypeFunction, Function: &definition})
+	}
+	return result, nil
+}
+
+// Call 执行模型请求的工具调用，失败时把错误信息作为结果返回给模型
+func Call(ctx context.Context, call gogpt.ToolCall) string {
+	tool, ok := Get(call.Function.Name)
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %s", call.Function.Name)
+	}
+	result, err := tool.Call(ctx, call.Function.Arguments)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+func init() {
+	Register(currentTime{})
+	Register(calculator{})
+}
@@ -9,30 +9,146 @@ import (
 var chatController = NewChatController()
 var userController = NewUserController()
 var authController = NewAuthController()
+var usageController = NewUsageController()
+var groupController = NewGroupController()
+var quotaController = NewQuotaController()
+var creditController = NewCreditController()
+var memoryController = NewMemoryController()
+var personaController = NewPersonaController()
+var templateController = NewTemplateController()
+var appController = NewAppController()
+var folderController = NewFolderController()
+var shareController = NewShareController()
 
 // RegisterWebRoutes 注册路由
 func RegisterWebRoutes(router *gin.Engine) {
 
 	router.Use(middlewares.Cors())
 	router.GET("", chatController.Index)
-	router.POST("user/auth", authController.Auth)
+	router.POST("user/auth", middlewares.RateLimitIP(), authController.Auth)
+	router.POST("user/forgotpassword", middlewares.RateLimitIP(), userController.ForgotPassword)
+	router.POST("user/resetpassword", middlewares.RateLimitIP(), userController.ResetPassword)
+	router.POST("user/verifyemail", middlewares.RateLimitIP(), userController.VerifyEmail)
+	router.GET("resetpassword", userController.ResetPasswordPage)
+	router.GET("verifyemail", userController.VerifyEmailPage)
+	router.GET("s/:token", middlewares.RateLimitIP(), shareController.View)
+	router.POST("s/:token", middlewares.RateLimitIP(), shareController.View)
 	chat := router.Group("/chat").Use(middlewares.Jwt())
 	{
-		chat.POST("/completion", chatController.Completion)
+		chat.POST("/completion", middlewares.RateLimitUser(), chatController.Completion)
+		chat.POST("/template", middlewares.RateLimitUser(), chatController.TemplateCompletion)
 		chat.POST("/userchatrecord", chatController.UserChatRecord)
 		chat.POST("/chatmessages", chatController.ChatMessages)
 		chat.POST("/renamesubject", chatController.RenameSubject)
+		chat.POST("/regeneratesubject", middlewares.RateLimitUser(), chatController.RegenerateSubject)
 		chat.POST("/deletechat", chatController.DeleteChat)
+		chat.POST("/bulk", chatController.Bulk)
+		chat.POST("/tags", chatController.Tags)
+		chat.POST("/search", chatController.Search)
+		chat.POST("/similar", middlewares.RateLimitUser(), chatController.Similar)
+		chat.POST("/editmessage", middlewares.RateLimitUser(), chatController.EditMessage)
+		chat.POST("/regenerate", middlewares.RateLimitUser(), chatController.Regenerate)
+		chat.POST("/continue", middlewares.RateLimitUser(), chatController.Continue)
+		chat.POST("/summary", chatController.Summary)
+		chat.POST("/setsummary", chatController.SetSummary)
+		chat.POST("/siblings", chatController.Siblings)
+		chat.POST("/switchbranch", chatController.SwitchBranch)
 		chat.POST("/getconfig", chatController.GetConfig)
 		chat.POST("/setconfig", chatController.SetConfig)
+		chat.POST("/getparams", chatController.GetParams)
+		chat.POST("/setparams", chatController.SetParams)
 	}
 	user := router.Group("/user").Use(middlewares.Jwt())
 	{
 		user.POST("/updatepassword", userController.UpdatePassword)
 		user.POST("/createuser", userController.CreateUser)
+		user.POST("/updateemail", userController.UpdateEmail)
+		user.POST("/sendverifyemail", userController.SendVerifyEmail)
+		user.POST("/setparams", userController.SetParams)
+		user.POST("/profile", userController.Profile)
+		user.POST("/saveprofile", userController.SaveProfile)
 	}
 	auth := router.Group("/auth").Use(middlewares.Jwt())
 	{
 		auth.POST("/info", authController.Info)
 	}
+	usage := router.Group("/usage").Use(middlewares.Jwt(), middlewares.Admin())
+	{
+		usage.POST("/users", usageController.UserUsage)
+		usage.POST("/models", usageController.ModelUsage)
+		usage.POST("/apps", usageController.AppUsage)
+	}
+	group := router.Group("/group").Use(middlewares.Jwt(), middlewares.Admin())
+	{
+		group.POST("/list", groupController.List)
+		group.POST("/save", groupController.Save)
+		group.POST("/delete", groupController.Delete)
+		group.POST("/setuser", groupController.SetUser)
+	}
+	quota := router.Group("/quota").Use(middlewares.Jwt(), middlewares.Admin())
+	{
+		quota.POST("/list", quotaController.List)
+		quota.POST("/save", quotaController.Save)
+		quota.POST("/delete", quotaController.Delete)
+		quota.POST("/reset", quotaController.Reset)
+		quota.POST("/override", quotaController.Override)
+	}
+	credit := router.Group("/credit").Use(middlewares.Jwt())
+	{
+		credit.POST("/balance", creditController.Balance)
+		credit.POST("/redeem", creditController.Redeem)
+	}
+	memory := router.Group("/memory").Use(middlewares.Jwt())
+	{
+		memory.POST("/list", memoryController.List)
+		memory.POST("/save", memoryController.Save)
+		memory.POST("/delete", memoryController.Delete)
+		memory.POST("/extract", middlewares.RateLimitUser(), memoryController.Extract)
+	}
+	persona := router.Group("/persona").Use(middlewares.Jwt())
+	{
+		persona.POST("/list", personaController.List)
+		persona.POST("/save", personaController.Save)
+		persona.POST("/delete", personaController.Delete)
+	}
+	template := router.Group("/template").Use(middlewares.Jwt())
+	{
+		template.POST("/list", templateController.List)
+		template.POST("/save", templateController.Save)
+		template.POST("/delete", templateController.Delete)
+		template.POST("/render", templateController.Render)
+		template.POST("/export", templateController.Export)
+		template.POST("/import", templateController.Import)
+	}
+	apps := router.Group("/app").Use(middlewares.Jwt())
+	{
+		apps.POST("/list", appController.List)
+		apps.POST("/run/:key", middlewares.RateLimitUser(), appController.Run)
+	}
+	appAdmin := router.Group("/app").Use(middlewares.Jwt(), middlewares.Admin())
+	{
+		appAdmin.POST("/all", appController.All)
+		appAdmin.POST("/save", appController.Save)
+		appAdmin.POST("/delete", appController.Delete)
+	}
+	share := router.Group("/share").Use(middlewares.Jwt())
+	{
+		share.POST("/create", shareController.Create)
+		share.POST("/list", shareController.List)
+		share.POST("/revoke", shareController.Revoke)
+		share.POST("/fork", middlewares.RateLimitUser(), shareController.Fork)
+	}
+	folder := router.Group("/folder").Use(middlewares.Jwt())
+	{
+		folder.POST("/list", folderController.List)
+		folder.POST("/save", folderController.Save)
+		folder.POST("/delete", folderController.Delete)
+	}
+	creditAdmin := router.Group("/credit").Use(middlewares.Jwt(), middlewares.Admin())
+	{
+		creditAdmin.POST("/generatecodes", creditController.GenerateCodes)
+		creditAdmin.POST("/codes", creditController.Codes)
+		creditAdmin.POST("/adjust", creditController.Adjust)
+		creditAdmin.POST("/export", creditController.Export)
+	}
 }